@@ -72,6 +72,11 @@ func RegisterInConsul(port int) *Service {
 		mainServiceId: viper.GetString("consul.main-service-id")}
 }
 
+// ServiceId возвращает id генератора в consul, под которым он сообщает главному сервису о завершении тестов
+func (service *Service) ServiceId() string {
+	return service.serviceId
+}
+
 func (service *Service) SendEndTestRequestToMain(testId string) {
 	mainService, _, err := service.consulAgent.Service(service.mainServiceId, &consulapi.QueryOptions{})
 	if err != nil {
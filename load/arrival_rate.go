@@ -0,0 +1,170 @@
+package load
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+const arrivalRateTick = 10 * time.Millisecond
+
+// userPool хранит свободных пользователей сценария с открытой моделью нагрузки.
+// Новые пользователи создаются, пока их число не достигнет MaxUsers. stop закрывается после окончания
+// расписания, чтобы прервать ожидания выполняющихся итераций.
+type userPool struct {
+	idle    chan *User
+	stop    chan struct{}
+	created int64
+	max     int64
+}
+
+func (scenario *Scenario) prepareArrivalRate(totalDuration float64) error {
+	if scenario.MaxUsers <= 0 {
+		return fmt.Errorf("scenario %s: maxUsers must be positive for %s executor", scenario.Name, ArrivalRateExecutor)
+	}
+
+	if totalDuration == 0 {
+		return nil
+	}
+	sumTimeBefore := 0.0
+	currentRate := 0.0
+	for i := 0; i < len(scenario.Steps); i++ {
+		step := &scenario.Steps[i]
+		if step.Action != RateAction && step.Action != DurationAction {
+			continue
+		}
+		if sumTimeBefore+step.Period > totalDuration {
+			if step.Action == RateAction {
+				step.Rate = currentRate + (step.Rate-currentRate)*(totalDuration-sumTimeBefore)/step.Period
+			}
+			step.Period = totalDuration - sumTimeBefore
+			scenario.Steps = scenario.Steps[:i+1]
+			break
+		}
+		if step.Action == RateAction {
+			currentRate = step.Rate
+		}
+		sumTimeBefore += step.Period
+	}
+	return nil
+}
+
+func (scenario *Scenario) runArrivalRate(testName string, testRunId string) {
+	pool := &userPool{idle: make(chan *User, scenario.MaxUsers), stop: make(chan struct{}), max: int64(scenario.MaxUsers)}
+	currentRate := 0.0
+	pendingIterations := 0.0
+
+	for _, step := range scenario.Steps {
+		if scenario.stopped.Load() {
+			break
+		}
+
+		targetRate := currentRate
+		if step.Action == RateAction {
+			targetRate = step.Rate
+		} else if step.Action != DurationAction {
+			continue
+		}
+
+		if !scenario.runRateStep(step.Period, currentRate, targetRate, &pendingIterations, pool, testName, testRunId) {
+			break
+		}
+		currentRate = targetRate
+	}
+
+	close(pool.stop)
+	scenario.runningUserWait.Wait()
+	close(pool.idle)
+	for user := range pool.idle {
+		scenario.stopUserHook(user, testName, testRunId)
+		scenario.test.releaseHttpClients(user)
+	}
+	scenario.addUsers(-atomic.LoadInt64(&pool.created), testName, testRunId)
+}
+
+// runRateStep запускает итерации с частотой, линейно меняющейся от startRate до targetRate за period секунд.
+// Возвращает false, если сценарий был остановлен.
+func (scenario *Scenario) runRateStep(period float64, startRate float64, targetRate float64, pendingIterations *float64,
+	pool *userPool, testName string, testRunId string) bool {
+	duration := time.Duration(period*1000) * time.Millisecond
+	rateAt := func(elapsed time.Duration) float64 {
+		if duration == 0 {
+			return targetRate
+		}
+		return startRate + (targetRate-startRate)*float64(elapsed)/float64(duration)
+	}
+
+	ticker := time.NewTicker(arrivalRateTick)
+	defer ticker.Stop()
+	stepStart := time.Now()
+	var lastElapsed time.Duration
+	for lastElapsed < duration {
+		select {
+		case <-scenario.stopScenarioChannel:
+			return false
+		case now := <-ticker.C:
+			elapsed := now.Sub(stepStart)
+			if elapsed > duration {
+				elapsed = duration
+			}
+			*pendingIterations += (rateAt(lastElapsed) + rateAt(elapsed)) / 2 * (elapsed - lastElapsed).Seconds()
+			lastElapsed = elapsed
+			for ; *pendingIterations >= 1; *pendingIterations-- {
+				scenario.startArrivalIteration(pool, testName, testRunId)
+			}
+		}
+	}
+	return true
+}
+
+func (scenario *Scenario) startArrivalIteration(pool *userPool, testName string, testRunId string) {
+	var user *User
+	select {
+	case user = <-pool.idle:
+	default:
+		if atomic.LoadInt64(&pool.created) >= pool.max {
+			droppedIterationCountMetric.WithLabelValues(runLabelValues(testName, testRunId, scenario.Name)...).Inc()
+			emit(CounterEvent, "iterations_dropped", 1, "test_name", testName, "test_run_id", testRunId,
+				"scenario_name", scenario.Name)
+			return
+		}
+		var err error
+		user, err = CreateUser(scenario.test.newHttpClient(), scenario.userScripts...)
+		if err != nil {
+			scenario.test.releaseHttpClients(user)
+			scenario.stopOnGenerationError(err, testRunId)
+			return
+		}
+		user.stop = pool.stop
+		user.interrupt = scenario.stopScenarioChannel
+		atomic.AddInt64(&pool.created, 1)
+		scenario.addUsers(1, testName, testRunId)
+	}
+
+	scenario.runningUserWait.Add(1)
+	go func() {
+		defer scenario.runningUserWait.Done()
+		if !user.started {
+			if !scenario.startUserHook(user, testName, testRunId) {
+				scenario.test.releaseHttpClients(user)
+				atomic.AddInt64(&pool.created, -1)
+				scenario.addUsers(-1, testName, testRunId)
+				return
+			}
+			user.started = true
+		}
+
+		switch scenario.runIteration(user, testName, testRunId) {
+		case iterationStopTest:
+			scenario.test.Stop()
+			fallthrough
+		case iterationStopUser, iterationFailedStopUser:
+			scenario.stopUserHook(user, testName, testRunId)
+			scenario.test.releaseHttpClients(user)
+			atomic.AddInt64(&pool.created, -1)
+			scenario.addUsers(-1, testName, testRunId)
+		default:
+			pool.idle <- user
+		}
+	}()
+}
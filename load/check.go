@@ -0,0 +1,126 @@
+package load
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type CheckType string
+
+const (
+	StatusCheck       CheckType = "status"
+	BodyContainsCheck CheckType = "bodyContains"
+	BodyRegexCheck    CheckType = "bodyRegex"
+	JsonEqualsCheck   CheckType = "jsonEquals"
+	MaxLatencyCheck   CheckType = "maxLatency"
+	HeaderCheck       CheckType = "header"
+)
+
+const (
+	requestErrorReason = "request_error"
+	statusReason       = "status"
+	extractorReason    = "extractor"
+	bodyReadReason     = "body_read"
+)
+
+// Check проверяет ответ на шаг. Если среди проверок шага нет status, ответ со статусом >= 300 считается неуспешным.
+// MaxLatency задается в миллисекундах, для header без Value проверяется только наличие заголовка.
+type Check struct {
+	Type       CheckType
+	Statuses   []int
+	Value      string
+	Regex      *regexp.Regexp
+	Path       string
+	Name       string
+	MaxLatency int64
+}
+
+func (step *Step) checkResponse(resp *response, latency int64) (string, error) {
+	hasStatusCheck := false
+	for _, check := range step.Checks {
+		if check.Type == StatusCheck {
+			hasStatusCheck = true
+		}
+		if err := check.verify(resp, latency); err != nil {
+			return string(check.Type), err
+		}
+	}
+
+	if !hasStatusCheck && resp.resp.StatusCode >= 300 {
+		return statusReason, fmt.Errorf("unexpected status %d", resp.resp.StatusCode)
+	}
+	return "", nil
+}
+
+func (check *Check) validate() error {
+	switch check.Type {
+	case StatusCheck:
+		if len(check.Statuses) == 0 {
+			return fmt.Errorf("statuses are not set")
+		}
+	case BodyRegexCheck:
+		if check.Regex == nil {
+			return fmt.Errorf("regex is not set")
+		}
+	case HeaderCheck:
+		if check.Name == "" {
+			return fmt.Errorf("name is not set")
+		}
+	case MaxLatencyCheck:
+		if check.MaxLatency <= 0 {
+			return fmt.Errorf("max latency must be positive")
+		}
+	case BodyContainsCheck, JsonEqualsCheck:
+	default:
+		return fmt.Errorf("unknown check type %q", check.Type)
+	}
+	return nil
+}
+
+func (check *Check) verify(resp *response, latency int64) error {
+	switch check.Type {
+	case StatusCheck:
+		for _, status := range check.Statuses {
+			if resp.resp.StatusCode == status {
+				return nil
+			}
+		}
+		return fmt.Errorf("status %d not in %v", resp.resp.StatusCode, check.Statuses)
+	case BodyContainsCheck:
+		if !strings.Contains(resp.body, check.Value) {
+			return fmt.Errorf("body doesn't contain %q", check.Value)
+		}
+	case BodyRegexCheck:
+		if !check.Regex.MatchString(resp.body) {
+			return fmt.Errorf("body doesn't match %v", check.Regex)
+		}
+	case JsonEqualsCheck:
+		data, err := resp.json()
+		if err != nil {
+			return err
+		}
+		value, err := extractJsonPath(data, check.Path)
+		if err != nil {
+			return err
+		}
+		if value != check.Value {
+			return fmt.Errorf("json path %s is %q, expected %q", check.Path, value, check.Value)
+		}
+	case MaxLatencyCheck:
+		if latency > check.MaxLatency {
+			return fmt.Errorf("latency %d ms exceeds %d ms", latency, check.MaxLatency)
+		}
+	case HeaderCheck:
+		values := resp.resp.Header.Values(check.Name)
+		if len(values) == 0 {
+			return fmt.Errorf("header %s not found", check.Name)
+		}
+		if check.Value != "" && values[0] != check.Value {
+			return fmt.Errorf("header %s is %q, expected %q", check.Name, values[0], check.Value)
+		}
+	default:
+		return fmt.Errorf("unknown check type %q", check.Type)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package load
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+)
+
+func testResponse(status int, body string) *response {
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	return &response{resp: &http.Response{StatusCode: status, Header: header}, body: body}
+}
+
+func TestCheckVerify(t *testing.T) {
+	body := `{"user":{"id":42,"name":"bob"}}`
+	cases := []struct {
+		check   Check
+		status  int
+		latency int64
+		valid   bool
+	}{
+		{Check{Type: StatusCheck, Statuses: []int{200, 201}}, 201, 0, true},
+		{Check{Type: StatusCheck, Statuses: []int{200}}, 404, 0, false},
+		{Check{Type: BodyContainsCheck, Value: `"name":"bob"`}, 200, 0, true},
+		{Check{Type: BodyContainsCheck, Value: "alice"}, 200, 0, false},
+		{Check{Type: BodyRegexCheck, Regex: regexp.MustCompile(`"id":\d+`)}, 200, 0, true},
+		{Check{Type: BodyRegexCheck, Regex: regexp.MustCompile(`"id":"\d+"`)}, 200, 0, false},
+		{Check{Type: JsonEqualsCheck, Path: "$.user.id", Value: "42"}, 200, 0, true},
+		{Check{Type: JsonEqualsCheck, Path: "$.user.name", Value: "alice"}, 200, 0, false},
+		{Check{Type: JsonEqualsCheck, Path: "$.user.email", Value: ""}, 200, 0, false},
+		{Check{Type: MaxLatencyCheck, MaxLatency: 100}, 200, 100, true},
+		{Check{Type: MaxLatencyCheck, MaxLatency: 100}, 200, 101, false},
+		{Check{Type: HeaderCheck, Name: "Content-Type"}, 200, 0, true},
+		{Check{Type: HeaderCheck, Name: "Content-Type", Value: "application/json"}, 200, 0, true},
+		{Check{Type: HeaderCheck, Name: "Content-Type", Value: "text/plain"}, 200, 0, false},
+		{Check{Type: HeaderCheck, Name: "Location"}, 200, 0, false},
+	}
+	for i, c := range cases {
+		if err := c.check.verify(testResponse(c.status, body), c.latency); (err == nil) != c.valid {
+			t.Errorf("case %d (%s): valid %v, got error %v", i, c.check.Type, c.valid, err)
+		}
+	}
+}
+
+func TestCheckValidate(t *testing.T) {
+	cases := []struct {
+		check Check
+		valid bool
+	}{
+		{Check{Type: StatusCheck, Statuses: []int{200}}, true},
+		{Check{Type: StatusCheck}, false},
+		{Check{Type: BodyRegexCheck}, false},
+		{Check{Type: HeaderCheck}, false},
+		{Check{Type: MaxLatencyCheck}, false},
+		{Check{Type: BodyContainsCheck, Value: "ok"}, true},
+		{Check{Type: "xpath"}, false},
+	}
+	for i, c := range cases {
+		if err := c.check.validate(); (err == nil) != c.valid {
+			t.Errorf("case %d (%s): valid %v, got error %v", i, c.check.Type, c.valid, err)
+		}
+	}
+}
+
+func TestCheckResponse(t *testing.T) {
+	cases := []struct {
+		checks []*Check
+		status int
+		reason string
+	}{
+		{nil, 200, ""},
+		{nil, 302, statusReason},
+		{nil, 500, statusReason},
+		{[]*Check{{Type: StatusCheck, Statuses: []int{302}}}, 302, ""},
+		{[]*Check{{Type: StatusCheck, Statuses: []int{200}}}, 500, string(StatusCheck)},
+		{[]*Check{{Type: BodyContainsCheck, Value: "ok"}}, 500, statusReason},
+		{[]*Check{{Type: MaxLatencyCheck, MaxLatency: 10}, {Type: BodyContainsCheck, Value: "missing"}}, 200,
+			string(BodyContainsCheck)},
+		{[]*Check{{Type: MaxLatencyCheck, MaxLatency: 1}}, 200, string(MaxLatencyCheck)},
+	}
+	for i, c := range cases {
+		step := &Step{Name: "step", Checks: c.checks}
+		reason, err := step.checkResponse(testResponse(c.status, "ok"), 5)
+		if reason != c.reason || (err == nil) != (c.reason == "") {
+			t.Errorf("case %d: got reason %q and error %v, expected reason %q", i, reason, err, c.reason)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package load
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+type DelayDistribution string
+
+const (
+	FixedDelay       DelayDistribution = "fixed"
+	UniformDelay     DelayDistribution = "uniform"
+	NormalDelay      DelayDistribution = "normal"
+	ExponentialDelay DelayDistribution = "exponential"
+)
+
+// Delay - случайная пауза в секундах. Value задает фиксированную паузу, Min и Max - границы равномерного
+// распределения, Mean и StdDev - параметры нормального и экспоненциального распределений.
+// Для normal и exponential ненулевой Max ограничивает паузу сверху.
+type Delay struct {
+	Distribution DelayDistribution
+	Value        float64
+	Min          float64
+	Max          float64
+	Mean         float64
+	StdDev       float64
+}
+
+func (delay *Delay) validate() error {
+	switch delay.Distribution {
+	case "", FixedDelay, NormalDelay, ExponentialDelay:
+		return nil
+	case UniformDelay:
+		if delay.Max < delay.Min {
+			return fmt.Errorf("delay max is less than min")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown delay distribution %q", delay.Distribution)
+	}
+}
+
+func (delay *Delay) duration(userRand *rand.Rand) time.Duration {
+	var seconds float64
+	switch delay.Distribution {
+	case UniformDelay:
+		seconds = delay.Min + userRand.Float64()*(delay.Max-delay.Min)
+	case NormalDelay:
+		seconds = userRand.NormFloat64()*delay.StdDev + delay.Mean
+	case ExponentialDelay:
+		seconds = userRand.ExpFloat64() * delay.Mean
+	default:
+		seconds = delay.Value
+	}
+
+	if delay.Distribution != UniformDelay && delay.Max > 0 {
+		seconds = math.Min(seconds, delay.Max)
+	}
+	if seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
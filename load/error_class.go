@@ -0,0 +1,66 @@
+package load
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	timeoutErrorClass           = "timeout"
+	connectionRefusedErrorClass = "connection_refused"
+	dnsErrorClass               = "dns"
+	tlsErrorClass               = "tls"
+	http4xxErrorClass           = "http_4xx"
+	http5xxErrorClass           = "http_5xx"
+	checkErrorClass             = "check"
+	otherErrorClass             = "other"
+
+	noStatusCode = "0"
+)
+
+// classifyError определяет класс ошибки по ошибке отправки запроса, а если ответ был получен - по его статусу
+func classifyError(err error, statusCode int) string {
+	if err == nil {
+		switch {
+		case statusCode >= 500:
+			return http5xxErrorClass
+		case statusCode >= 400:
+			return http4xxErrorClass
+		default:
+			return checkErrorClass
+		}
+	}
+
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var recordErr tls.RecordHeaderError
+	var authorityErr x509.UnknownAuthorityError
+	var certificateErr x509.CertificateInvalidError
+	var hostnameErr x509.HostnameError
+	switch {
+	case errors.As(err, &dnsErr):
+		return dnsErrorClass
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return timeoutErrorClass
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return connectionRefusedErrorClass
+	case errors.As(err, &recordErr), errors.As(err, &authorityErr), errors.As(err, &certificateErr),
+		errors.As(err, &hostnameErr), strings.Contains(err.Error(), "tls:"):
+		return tlsErrorClass
+	default:
+		return otherErrorClass
+	}
+}
+
+func statusCodeLabel(statusCode int) string {
+	if statusCode == 0 {
+		return noStatusCode
+	}
+	return strconv.Itoa(statusCode)
+}
@@ -0,0 +1,73 @@
+package load
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+type ErrorAction string
+
+const (
+	AbortIterationAction ErrorAction = "abortIteration"
+	ContinueAction       ErrorAction = "continue"
+	RetryAction          ErrorAction = "retry"
+	StopUserAction       ErrorAction = "stopUser"
+)
+
+// ErrorPolicy задает поведение при неуспешном шаге. Для retry шаг повторяется до Retries раз с паузой Backoff,
+// которая умножается на Multiplier после каждой попытки. Если все попытки неуспешны, итерация прерывается.
+type ErrorPolicy struct {
+	Action     ErrorAction
+	Retries    int
+	Backoff    *Delay
+	Multiplier float64
+}
+
+func (policy *ErrorPolicy) validate() error {
+	switch policy.Action {
+	case "", AbortIterationAction, ContinueAction, StopUserAction:
+		return nil
+	case RetryAction:
+		if policy.Retries <= 0 {
+			return fmt.Errorf("retries must be positive for %s action", RetryAction)
+		}
+		if policy.Backoff != nil {
+			return policy.Backoff.validate()
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown action %q", policy.Action)
+	}
+}
+
+func (policy *ErrorPolicy) retryAllowed(attempt int) bool {
+	return policy != nil && policy.Action == RetryAction && attempt <= policy.Retries
+}
+
+func (policy *ErrorPolicy) backoff(attempt int, userRand *rand.Rand) time.Duration {
+	if policy.Backoff == nil {
+		return 0
+	}
+	backoff := policy.Backoff.duration(userRand)
+	if policy.Multiplier > 0 {
+		backoff = time.Duration(float64(backoff) * math.Pow(policy.Multiplier, float64(attempt-1)))
+	}
+	return backoff
+}
+
+func (policy *ErrorPolicy) resultOnFailure(iter *iteration) iterationResult {
+	if policy == nil {
+		return iterationFailed
+	}
+	switch policy.Action {
+	case ContinueAction:
+		iter.failed = true
+		return iterationSucceeded
+	case StopUserAction:
+		return iterationFailedStopUser
+	default:
+		return iterationFailed
+	}
+}
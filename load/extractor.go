@@ -0,0 +1,179 @@
+package load
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type ExtractorType string
+
+const (
+	RegexExtractor  ExtractorType = "regex"
+	JsonExtractor   ExtractorType = "json"
+	HeaderExtractor ExtractorType = "header"
+	CookieExtractor ExtractorType = "cookie"
+)
+
+// Extractor сохраняет значение из ответа в переменную пользователя. Regex и Group используются для regex,
+// Path (например, $.data.items[0].id) для json, Name для header и cookie.
+// Если Group не задан, а в регулярном выражении есть группы, берется первая группа.
+type Extractor struct {
+	Variable string
+	Type     ExtractorType
+	Regex    *regexp.Regexp
+	Group    int
+	Path     string
+	Name     string
+	Default  *string
+}
+
+type response struct {
+	resp       *http.Response
+	body       string
+	jsonBody   interface{}
+	jsonParsed bool
+	jsonErr    error
+}
+
+func (step *Step) extractVariables(resp *response, scriptVariables map[string]string) error {
+	for _, extractor := range step.Extractors {
+		value, err := extractor.extract(resp)
+		if err != nil {
+			if extractor.Default == nil {
+				return fmt.Errorf("extractor for variable %s: %w", extractor.Variable, err)
+			}
+			value = *extractor.Default
+		}
+		scriptVariables[extractor.Variable] = value
+	}
+	return nil
+}
+
+func (extractor *Extractor) validate() error {
+	switch extractor.Type {
+	case RegexExtractor:
+		if extractor.Regex == nil {
+			return fmt.Errorf("regex is not set")
+		}
+		if extractor.Group < 0 || extractor.Group > extractor.Regex.NumSubexp() {
+			return fmt.Errorf("regex %s has no group %d", extractor.Regex, extractor.Group)
+		}
+	case JsonExtractor:
+	case HeaderExtractor, CookieExtractor:
+		if extractor.Name == "" {
+			return fmt.Errorf("name is not set")
+		}
+	default:
+		return fmt.Errorf("unknown extractor type %q", extractor.Type)
+	}
+	return nil
+}
+
+func (extractor *Extractor) extract(resp *response) (string, error) {
+	switch extractor.Type {
+	case RegexExtractor:
+		return extractor.extractByRegex(resp.body)
+	case JsonExtractor:
+		data, err := resp.json()
+		if err != nil {
+			return "", err
+		}
+		return extractJsonPath(data, extractor.Path)
+	case HeaderExtractor:
+		values := resp.resp.Header.Values(extractor.Name)
+		if len(values) == 0 {
+			return "", fmt.Errorf("header %s not found", extractor.Name)
+		}
+		return values[0], nil
+	case CookieExtractor:
+		for _, cookie := range resp.resp.Cookies() {
+			if cookie.Name == extractor.Name {
+				return cookie.Value, nil
+			}
+		}
+		return "", fmt.Errorf("cookie %s not found", extractor.Name)
+	default:
+		return "", fmt.Errorf("unknown extractor type %q", extractor.Type)
+	}
+}
+
+func (extractor *Extractor) extractByRegex(body string) (string, error) {
+	group := extractor.Group
+	if group == 0 && extractor.Regex.NumSubexp() > 0 {
+		group = 1
+	}
+	match := extractor.Regex.FindStringSubmatch(body)
+	if match == nil {
+		return "", fmt.Errorf("regex %s not matched", extractor.Regex)
+	}
+	return match[group], nil
+}
+
+func (resp *response) json() (interface{}, error) {
+	if !resp.jsonParsed {
+		decoder := json.NewDecoder(strings.NewReader(resp.body))
+		decoder.UseNumber()
+		resp.jsonErr = decoder.Decode(&resp.jsonBody)
+		resp.jsonParsed = true
+	}
+	return resp.jsonBody, resp.jsonErr
+}
+
+func extractJsonPath(data interface{}, path string) (string, error) {
+	value, err := lookupJsonPath(data, path)
+	if err != nil {
+		return "", err
+	}
+	return jsonValueToString(value)
+}
+
+func lookupJsonPath(data interface{}, path string) (interface{}, error) {
+	keys := strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	keys = strings.ReplaceAll(strings.ReplaceAll(keys, "[", "."), "]", "")
+	if keys == "" {
+		return data, nil
+	}
+
+	current := data
+	for _, key := range strings.Split(keys, ".") {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			value, ok := node[key]
+			if !ok {
+				return nil, fmt.Errorf("json path %s: field %s not found", path, key)
+			}
+			current = value
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(node) {
+				return nil, fmt.Errorf("json path %s: index %s out of range", path, key)
+			}
+			current = node[index]
+		default:
+			return nil, fmt.Errorf("json path %s: can't get %s from scalar value", path, key)
+		}
+	}
+	return current, nil
+}
+
+func jsonValueToString(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	default:
+		var buf bytes.Buffer
+		encoder := json.NewEncoder(&buf)
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(v); err != nil {
+			return "", err
+		}
+		return strings.TrimSuffix(buf.String(), "\n"), nil
+	}
+}
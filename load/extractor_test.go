@@ -0,0 +1,81 @@
+package load
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+)
+
+func TestExtractJsonPath(t *testing.T) {
+	resp := &response{body: `{"data":{"items":[{"id":12345678901234567890,"name":"first"},{"id":2,"tags":["a","b"]}]},"ok":true}`}
+	data, err := resp.json()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		path     string
+		expected string
+	}{
+		{"$.data.items[0].id", "12345678901234567890"},
+		{"data.items[0].name", "first"},
+		{"$.data.items[1].tags", `["a","b"]`},
+		{"$.ok", "true"},
+		{"$.data.items[1]", `{"id":2,"tags":["a","b"]}`},
+	}
+	for _, c := range cases {
+		value, err := extractJsonPath(data, c.path)
+		if err != nil {
+			t.Errorf("%s: %v", c.path, err)
+		} else if value != c.expected {
+			t.Errorf("%s: got %q, expected %q", c.path, value, c.expected)
+		}
+	}
+
+	for _, path := range []string{"$.missing", "$.data.items[2]", "$.data.items[-1]", "$.ok.value", "$.data.items.id"} {
+		if value, err := extractJsonPath(data, path); err == nil {
+			t.Errorf("%s: expected error, got %q", path, value)
+		}
+	}
+}
+
+func TestExtractorValidate(t *testing.T) {
+	regex := regexp.MustCompile(`id=(\d+)`)
+	cases := []struct {
+		extractor *Extractor
+		valid     bool
+	}{
+		{&Extractor{Type: RegexExtractor, Regex: regex}, true},
+		{&Extractor{Type: RegexExtractor, Regex: regex, Group: 1}, true},
+		{&Extractor{Type: RegexExtractor, Regex: regex, Group: 2}, false},
+		{&Extractor{Type: RegexExtractor, Regex: regex, Group: -1}, false},
+		{&Extractor{Type: RegexExtractor}, false},
+		{&Extractor{Type: JsonExtractor, Path: "$.id"}, true},
+		{&Extractor{Type: HeaderExtractor, Name: "Location"}, true},
+		{&Extractor{Type: CookieExtractor}, false},
+		{&Extractor{Type: "xpath"}, false},
+	}
+	for i, c := range cases {
+		if err := c.extractor.validate(); (err == nil) != c.valid {
+			t.Errorf("case %d: valid %v, got error %v", i, c.valid, err)
+		}
+	}
+}
+
+func TestExtractByRegex(t *testing.T) {
+	resp := &response{resp: &http.Response{Header: http.Header{}}, body: "user id=42 name=bob"}
+	cases := []struct {
+		extractor *Extractor
+		expected  string
+	}{
+		{&Extractor{Type: RegexExtractor, Regex: regexp.MustCompile(`id=(\d+)`)}, "42"},
+		{&Extractor{Type: RegexExtractor, Regex: regexp.MustCompile(`id=\d+`)}, "id=42"},
+		{&Extractor{Type: RegexExtractor, Regex: regexp.MustCompile(`id=(\d+) name=(\w+)`), Group: 2}, "bob"},
+	}
+	for i, c := range cases {
+		value, err := c.extractor.extract(resp)
+		if err != nil || value != c.expected {
+			t.Errorf("case %d: got %q, %v, expected %q", i, value, err, c.expected)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package load
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+const maxWhileIterations = 10000
+
+type ConditionOperator string
+
+const (
+	ExistsCondition    ConditionOperator = "exists"
+	NotExistsCondition ConditionOperator = "notExists"
+	EqualsCondition    ConditionOperator = "equals"
+	NotEqualsCondition ConditionOperator = "notEquals"
+	MatchesCondition   ConditionOperator = "matches"
+	LessCondition      ConditionOperator = "less"
+	GreaterCondition   ConditionOperator = "greater"
+)
+
+// Condition проверяет значение переменной пользователя. Для less и greater значения сравниваются как числа.
+type Condition struct {
+	Variable string
+	Operator ConditionOperator
+	Value    string
+	Regex    *regexp.Regexp
+}
+
+// Repeat повторяет шаг Count раз, случайное число раз от Min до Max или, если задан While, пока условие выполняется.
+type Repeat struct {
+	Count int
+	Min   int
+	Max   int
+	While *Condition
+}
+
+type Branch struct {
+	Weight float64
+	Steps  []*Step
+}
+
+func forEachStep(steps []*Step, action func(step *Step) error) error {
+	for _, step := range steps {
+		if err := action(step); err != nil {
+			return err
+		}
+		if err := forEachStep(step.Steps, action); err != nil {
+			return err
+		}
+		for _, branch := range step.Branches {
+			if err := forEachStep(branch.Steps, action); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (step *Step) validateFlow(knownVariables map[string]bool) error {
+	if len(step.Steps) > 0 && len(step.Branches) > 0 {
+		return fmt.Errorf("steps and branches can't be set together")
+	}
+	if len(step.Steps) > 0 || len(step.Branches) > 0 {
+		if field := step.requestField(); field != "" {
+			return fmt.Errorf("%s can't be set for a step with nested steps or branches", field)
+		}
+	}
+	if step.When != nil {
+		if err := step.When.validate(knownVariables); err != nil {
+			return fmt.Errorf("when: %w", err)
+		}
+	}
+	if step.Repeat != nil {
+		if step.Repeat.Max < step.Repeat.Min {
+			return fmt.Errorf("repeat max is less than min")
+		}
+		if step.Repeat.While != nil {
+			if err := step.Repeat.While.validate(knownVariables); err != nil {
+				return fmt.Errorf("repeat: %w", err)
+			}
+		}
+	}
+	sum := 0.0
+	for _, branch := range step.Branches {
+		if branch.Weight < 0 {
+			return fmt.Errorf("negative branch weight %v", branch.Weight)
+		}
+		sum += branch.Weight
+	}
+	if len(step.Branches) > 0 && sum == 0 {
+		return fmt.Errorf("sum of branch weights is zero")
+	}
+	return nil
+}
+
+// requestField возвращает первое заданное поле запроса. У шага с вложенными шагами или ветками они не используются.
+func (step *Step) requestField() string {
+	switch {
+	case step.Url != "":
+		return "url"
+	case step.Method != "":
+		return "method"
+	case step.Message != "":
+		return "body"
+	case len(step.Headers) > 0:
+		return "headers"
+	case step.Timeout != 0:
+		return "timeout"
+	case len(step.Extractors) > 0:
+		return "extractors"
+	case len(step.Checks) > 0:
+		return "checks"
+	case step.ThinkTime != nil:
+		return "thinkTime"
+	case step.OnError != nil:
+		return "onError"
+	case step.Tls != nil:
+		return "tls"
+	}
+	return ""
+}
+
+func (script *Script) runSteps(steps []*Step, testName string, user *User, iter *iteration) iterationResult {
+	for _, step := range steps {
+		if result := script.runStep(step, testName, user, iter); result != iterationSucceeded {
+			return result
+		}
+	}
+	return iterationSucceeded
+}
+
+func (script *Script) runStep(step *Step, testName string, user *User, iter *iteration) iterationResult {
+	if step.When != nil && !step.When.holds(user.scriptVariables) {
+		return iterationSucceeded
+	}
+	if step.Repeat == nil {
+		return script.runStepBody(step, testName, user, iter)
+	}
+
+	count := step.Repeat.count(user)
+	for i := 0; i < count; i++ {
+		if step.Repeat.While != nil && !step.Repeat.While.holds(user.scriptVariables) {
+			break
+		}
+		if result := script.runStepBody(step, testName, user, iter); result != iterationSucceeded {
+			return result
+		}
+	}
+	return iterationSucceeded
+}
+
+func (script *Script) runStepBody(step *Step, testName string, user *User, iter *iteration) iterationResult {
+	switch {
+	case len(step.Branches) > 0:
+		return script.runSteps(step.chooseBranch(user).Steps, testName, user, iter)
+	case len(step.Steps) > 0:
+		return script.runSteps(step.Steps, testName, user, iter)
+	default:
+		return script.processRequest(testName, user, iter, step)
+	}
+}
+
+func (step *Step) chooseBranch(user *User) *Branch {
+	sum := 0.0
+	for _, branch := range step.Branches {
+		sum += branch.Weight
+	}
+	point := user.userRand.Float64() * sum
+	for _, branch := range step.Branches {
+		if point < branch.Weight {
+			return branch
+		}
+		point -= branch.Weight
+	}
+	return step.Branches[len(step.Branches)-1]
+}
+
+// count возвращает число повторений, для цикла только с условием While оно ограничено maxWhileIterations
+func (repeat *Repeat) count(user *User) int {
+	switch {
+	case repeat.Count > 0:
+		return repeat.Count
+	case repeat.Max > 0:
+		return repeat.Min + user.userRand.Intn(repeat.Max-repeat.Min+1)
+	case repeat.While != nil:
+		return maxWhileIterations
+	default:
+		return 1
+	}
+}
+
+func (condition *Condition) validate(knownVariables map[string]bool) error {
+	if !knownVariables[condition.Variable] {
+		return fmt.Errorf("unknown variable %s", condition.Variable)
+	}
+	switch condition.Operator {
+	case ExistsCondition, NotExistsCondition, EqualsCondition, NotEqualsCondition:
+		return nil
+	case MatchesCondition:
+		if condition.Regex == nil {
+			return fmt.Errorf("regex is not set for %s condition", condition.Operator)
+		}
+		return nil
+	case LessCondition, GreaterCondition:
+		if _, err := strconv.ParseFloat(condition.Value, 64); err != nil {
+			return fmt.Errorf("value of %s condition must be a number: %w", condition.Operator, err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown condition operator %q", condition.Operator)
+	}
+}
+
+func (condition *Condition) holds(values map[string]string) bool {
+	value, exists := values[condition.Variable]
+	switch condition.Operator {
+	case ExistsCondition:
+		return exists
+	case NotExistsCondition:
+		return !exists
+	case EqualsCondition:
+		return exists && value == condition.Value
+	case NotEqualsCondition:
+		return value != condition.Value
+	case MatchesCondition:
+		return exists && condition.Regex.MatchString(value)
+	case LessCondition, GreaterCondition:
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return false
+		}
+		bound, _ := strconv.ParseFloat(condition.Value, 64)
+		if condition.Operator == LessCondition {
+			return number < bound
+		}
+		return number > bound
+	}
+	return false
+}
@@ -0,0 +1,26 @@
+package load
+
+import "testing"
+
+func TestValidateFlowRejectsMixedSteps(t *testing.T) {
+	nested := []*Step{{Name: "nested", Url: "http://localhost"}}
+	branches := []*Branch{{Weight: 1, Steps: nested}}
+	cases := []struct {
+		step  *Step
+		valid bool
+	}{
+		{&Step{Name: "group", Steps: nested}, true},
+		{&Step{Name: "branches", Branches: branches, Repeat: &Repeat{Count: 2}}, true},
+		{&Step{Name: "request", Url: "http://localhost", Checks: []*Check{{Type: StatusCheck, Statuses: []int{200}}}}, true},
+		{&Step{Name: "both", Steps: nested, Branches: branches}, false},
+		{&Step{Name: "group with url", Steps: nested, Url: "http://localhost"}, false},
+		{&Step{Name: "branches with checks", Branches: branches, Checks: []*Check{{Type: StatusCheck}}}, false},
+		{&Step{Name: "group with extractors", Steps: nested, Extractors: []*Extractor{{Variable: "id"}}}, false},
+		{&Step{Name: "group with think time", Steps: nested, ThinkTime: &Delay{Value: 1}}, false},
+	}
+	for _, c := range cases {
+		if err := c.step.validateFlow(map[string]bool{}); (err == nil) != c.valid {
+			t.Errorf("%s: valid %v, got error %v", c.step.Name, c.valid, err)
+		}
+	}
+}
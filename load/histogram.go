@@ -0,0 +1,153 @@
+package load
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	histogramMinValue = float64(time.Microsecond)
+	histogramGrowth   = 1.01
+)
+
+// histogram хранит распределение длительностей в логарифмических корзинах с относительной точностью около 1%,
+// поэтому перцентили считаются без хранения всех значений
+type histogram struct {
+	mutex   sync.Mutex
+	buckets map[int]uint64
+	count   uint64
+	failed  uint64
+	sum     time.Duration
+	min     time.Duration
+	max     time.Duration
+}
+
+func newHistogram() *histogram {
+	return &histogram{buckets: make(map[int]uint64)}
+}
+
+// record учитывает одну транзакцию или итерацию, для nil гистограммы ничего не делает
+func (h *histogram) record(duration time.Duration, failed bool) {
+	if h == nil {
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.buckets[bucketIndex(duration)]++
+	if h.count == 0 || duration < h.min {
+		h.min = duration
+	}
+	if duration > h.max {
+		h.max = duration
+	}
+	h.count++
+	h.sum += duration
+	if failed {
+		h.failed++
+	}
+}
+
+func (h *histogram) merge(other *histogram) {
+	other.mutex.Lock()
+	defer other.mutex.Unlock()
+	if other.count == 0 {
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for index, count := range other.buckets {
+		h.buckets[index] += count
+	}
+	if h.count == 0 || other.min < h.min {
+		h.min = other.min
+	}
+	if other.max > h.max {
+		h.max = other.max
+	}
+	h.count += other.count
+	h.failed += other.failed
+	h.sum += other.sum
+}
+
+// summary считает итоговую статистику, пропускная способность считается за elapsed
+func (h *histogram) summary(elapsed time.Duration) *Summary {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	summary := &Summary{Count: h.count, Failed: h.failed}
+	if h.count == 0 {
+		return summary
+	}
+
+	quantiles := h.quantiles([]float64{0.5, 0.9, 0.95, 0.99})
+	summary.ErrorRate = float64(h.failed) / float64(h.count)
+	summary.Min = durationMillis(h.min)
+	summary.Avg = durationMillis(h.sum / time.Duration(h.count))
+	summary.P50 = durationMillis(quantiles[0])
+	summary.P90 = durationMillis(quantiles[1])
+	summary.P95 = durationMillis(quantiles[2])
+	summary.P99 = durationMillis(quantiles[3])
+	summary.Max = durationMillis(h.max)
+	if elapsed > 0 {
+		summary.Throughput = float64(h.count) / elapsed.Seconds()
+	}
+	return summary
+}
+
+// snapshot возвращает число значений, их сумму и квантили qs
+func (h *histogram) snapshot(qs []float64) (uint64, time.Duration, []time.Duration) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.count, h.sum, h.quantiles(qs)
+}
+
+// quantiles вызывается под mutex
+func (h *histogram) quantiles(qs []float64) []time.Duration {
+	indexes := make([]int, 0, len(h.buckets))
+	for index := range h.buckets {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	values := make([]time.Duration, len(qs))
+	for i, q := range qs {
+		rank := uint64(math.Ceil(q * float64(h.count)))
+		values[i] = h.max
+		var seen uint64
+		for _, index := range indexes {
+			seen += h.buckets[index]
+			if seen >= rank {
+				values[i] = h.clamp(bucketValue(index))
+				break
+			}
+		}
+	}
+	return values
+}
+
+func (h *histogram) clamp(value time.Duration) time.Duration {
+	if value < h.min {
+		return h.min
+	}
+	if value > h.max {
+		return h.max
+	}
+	return value
+}
+
+func bucketIndex(duration time.Duration) int {
+	if float64(duration) <= histogramMinValue {
+		return 0
+	}
+	return int(math.Ceil(math.Log(float64(duration)/histogramMinValue) / math.Log(histogramGrowth)))
+}
+
+// bucketValue возвращает верхнюю границу корзины
+func bucketValue(index int) time.Duration {
+	return time.Duration(histogramMinValue * math.Pow(histogramGrowth, float64(index)))
+}
+
+func durationMillis(duration time.Duration) float64 {
+	return float64(duration) / float64(time.Millisecond)
+}
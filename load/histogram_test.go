@@ -0,0 +1,86 @@
+package load
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+func assertClose(t *testing.T, name string, got time.Duration, expected time.Duration) {
+	t.Helper()
+	if math.Abs(float64(got-expected)) > 0.01*float64(expected) {
+		t.Errorf("%s: got %v, expected %v within 1%%", name, got, expected)
+	}
+}
+
+func TestHistogramQuantiles(t *testing.T) {
+	h := newHistogram()
+	random := rand.New(rand.NewSource(1))
+	values := make([]time.Duration, 10000)
+	for i := range values {
+		values[i] = time.Duration(random.ExpFloat64() * float64(50*time.Millisecond))
+		h.record(values[i], i%10 == 0)
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	count, _, quantiles := h.snapshot([]float64{0.5, 0.99, 1})
+	if count != uint64(len(values)) {
+		t.Errorf("got count %d, expected %d", count, len(values))
+	}
+	assertClose(t, "p50", quantiles[0], values[len(values)/2-1])
+	assertClose(t, "p99", quantiles[1], values[len(values)*99/100-1])
+	if quantiles[2] != values[len(values)-1] {
+		t.Errorf("p100 %v differs from max %v", quantiles[2], values[len(values)-1])
+	}
+
+	summary := h.summary(10 * time.Second)
+	if summary.Min != durationMillis(values[0]) || summary.Max != durationMillis(values[len(values)-1]) {
+		t.Errorf("got min %v and max %v, expected %v and %v", summary.Min, summary.Max,
+			durationMillis(values[0]), durationMillis(values[len(values)-1]))
+	}
+	if summary.Failed != 1000 || summary.ErrorRate != 0.1 || summary.Throughput != 1000 {
+		t.Errorf("got %d failed, error rate %v, throughput %v", summary.Failed, summary.ErrorRate, summary.Throughput)
+	}
+	if summary.P50 < summary.Min || summary.P50 > summary.P90 || summary.P90 > summary.P95 ||
+		summary.P95 > summary.P99 || summary.P99 > summary.Max {
+		t.Errorf("percentiles are not ordered: %+v", summary)
+	}
+}
+
+func TestHistogramSingleValue(t *testing.T) {
+	h := newHistogram()
+	h.record(1234*time.Microsecond, false)
+	summary := h.summary(0)
+	if summary.Min != 1.234 || summary.P50 != 1.234 || summary.P99 != 1.234 || summary.Max != 1.234 ||
+		summary.Throughput != 0 {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+	if empty := newHistogram().summary(time.Second); empty.Count != 0 || empty.P99 != 0 {
+		t.Errorf("unexpected empty summary %+v", empty)
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	fast, slow, merged := newHistogram(), newHistogram(), newHistogram()
+	for i := 1; i <= 100; i++ {
+		fast.record(time.Duration(i)*time.Millisecond, false)
+		slow.record(time.Duration(i)*time.Second, i > 90)
+	}
+	merged.merge(fast)
+	merged.merge(slow)
+	merged.merge(newHistogram())
+
+	summary := merged.summary(0)
+	if summary.Count != 200 || summary.Failed != 10 || summary.Min != 1 || summary.Max != 100000 {
+		t.Errorf("unexpected merged summary %+v", summary)
+	}
+	_, sum, quantiles := merged.snapshot([]float64{0.5, 0.51, 0.99})
+	if expected := 5050*time.Millisecond + 5050*time.Second; sum != expected {
+		t.Errorf("got sum %v, expected %v", sum, expected)
+	}
+	assertClose(t, "p50", quantiles[0], 100*time.Millisecond)
+	assertClose(t, "p51", quantiles[1], 2*time.Second)
+	assertClose(t, "p99", quantiles[2], 98*time.Second)
+}
@@ -0,0 +1,63 @@
+package load
+
+import (
+	"github.com/rs/zerolog/log"
+)
+
+// runHook выполняет скрипт подготовки или завершения теста одним пользователем и возвращает его переменные
+func (test *Test) runHook(script *Script, values map[string]string) (map[string]string, bool) {
+	user, err := CreateUser(test.newHttpClient(), script)
+	defer test.releaseHttpClients(user)
+	if err != nil {
+		log.Error().Err(err).Str("testRunId", test.Id).Str("script", script.Name).
+			Msg("Не удалось сгенерировать переменные пользователя")
+		return nil, false
+	}
+	for name, value := range values {
+		user.scriptVariables[name] = value
+	}
+	result := script.ProcessHttp(test.Name, test.Id, "", user)
+	return user.scriptVariables, result == iterationSucceeded
+}
+
+func (test *Test) runTeardown(setupValues map[string]string) {
+	if test.Teardown == nil {
+		return
+	}
+	if _, success := test.runHook(test.Teardown, setupValues); !success {
+		log.Error().Str("testRunId", test.Id).Msg("Завершение теста выполнено с ошибкой")
+	}
+}
+
+// shareSetupValues делает переменные, полученные при подготовке теста, доступными всем пользователям
+func (test *Test) shareSetupValues(setupValues map[string]string) {
+	for _, scenario := range test.Scenarios {
+		for _, script := range scenario.userScripts {
+			for name, value := range setupValues {
+				script.sharedValues[name] = value
+			}
+		}
+	}
+}
+
+func (scenario *Scenario) startUserHook(user *User, testName string, testRunId string) bool {
+	if scenario.OnStart == nil {
+		return true
+	}
+	if scenario.OnStart.ProcessHttp(testName, testRunId, scenario.Name, user) != iterationSucceeded {
+		log.Error().Str("testRunId", testRunId).Str("scenario", scenario.Name).Str("userId", user.id).
+			Msg("Скрипт запуска пользователя завершился с ошибкой, пользователь остановлен")
+		return false
+	}
+	return true
+}
+
+func (scenario *Scenario) stopUserHook(user *User, testName string, testRunId string) {
+	if scenario.OnStop == nil {
+		return
+	}
+	if scenario.OnStop.ProcessHttp(testName, testRunId, scenario.Name, user) != iterationSucceeded {
+		log.Error().Str("testRunId", testRunId).Str("scenario", scenario.Name).Str("userId", user.id).
+			Msg("Скрипт остановки пользователя завершился с ошибкой")
+	}
+}
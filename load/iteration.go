@@ -2,9 +2,23 @@ package load
 
 const iterationIdLength = 15
 
+type iterationResult int
+
+const (
+	iterationSucceeded iterationResult = iota
+	iterationFailed
+	iterationStopUser
+	iterationStopTest
+	// iterationFailedStopUser - итерация неуспешна, и по политике ошибок шага пользователь останавливается
+	iterationFailedStopUser
+)
+
 type iteration struct {
-	id         string
-	testRunId  string
-	scriptName string
-	userId     string
+	id           string
+	testName     string
+	testRunId    string
+	scenarioName string
+	scriptName   string
+	userId       string
+	failed       bool
 }
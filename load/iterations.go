@@ -0,0 +1,59 @@
+package load
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+func (scenario *Scenario) prepareIterations(totalDuration float64) error {
+	if scenario.Users <= 0 || scenario.Iterations <= 0 {
+		return fmt.Errorf("scenario %s: users and iterations must be positive for %s executor", scenario.Name, scenario.Executor)
+	}
+	scenario.remainingIterations = int64(scenario.Iterations)
+	scenario.maxDuration = totalDuration
+	return nil
+}
+
+// runIterations запускает Users пользователей сразу и ждет, пока они выполнят все итерации,
+// сценарий не будет остановлен или не пройдет общая длительность теста
+func (scenario *Scenario) runIterations(testName string, testRunId string) {
+	usersWait := &sync.WaitGroup{}
+	usersWait.Add(scenario.Users)
+	for i := 0; i < scenario.Users; i++ {
+		scenario.runningUserWait.Add(1)
+		go func() {
+			scenario.StartUser(testName, testRunId)
+			usersWait.Done()
+			scenario.runningUserWait.Done()
+		}()
+	}
+
+	usersDone := make(chan struct{})
+	go func() {
+		usersWait.Wait()
+		close(usersDone)
+	}()
+
+	var timeout <-chan time.Time
+	if scenario.maxDuration > 0 {
+		timeout = time.After(time.Duration(scenario.maxDuration*1000) * time.Millisecond)
+	}
+	select {
+	case <-usersDone:
+	case <-scenario.stopScenarioChannel:
+	case <-timeout:
+	}
+}
+
+func (scenario *Scenario) acquireIteration(userIterations int) bool {
+	switch scenario.Executor {
+	case SharedIterationsExecutor:
+		return atomic.AddInt64(&scenario.remainingIterations, -1) >= 0
+	case PerUserIterationsExecutor:
+		return userIterations < scenario.Iterations
+	default:
+		return true
+	}
+}
@@ -0,0 +1,261 @@
+package load
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var defaultQuantiles = []float64{0.5, 0.9, 0.95, 0.99}
+
+// MetricsOptions задает корзины гистограмм длительностей в секундах: TransactionBuckets для транзакций и их этапов,
+// ScenarioBuckets для итераций сценариев. Если Summary включен, для успешных транзакций и итераций дополнительно
+// публикуются квантили Quantiles (по умолчанию 0.5, 0.9, 0.95, 0.99), посчитанные с точностью около 1%
+// по всем значениям запуска.
+type MetricsOptions struct {
+	TransactionBuckets []float64
+	ScenarioBuckets    []float64
+	Summary            bool
+	Quantiles          []float64
+}
+
+// latencyMetrics - метрики длительностей запуска со своей раскладкой корзин. Если метка test_run_id отключена,
+// одновременные запуски одного теста публикуют одни и те же серии и поэтому используют общие метрики.
+type latencyMetrics struct {
+	transactionBuckets   []float64
+	scenarioBuckets      []float64
+	runs                 map[string]bool
+	transactionSuccess   *prometheus.HistogramVec
+	transactionFailed    *prometheus.HistogramVec
+	transactionPhase     *prometheus.HistogramVec
+	scenarioSuccess      *prometheus.HistogramVec
+	quantiles            []float64
+	summaryMutex         sync.Mutex
+	transactionSummaries map[string]*summarySeries
+	scenarioSummaries    map[string]*summarySeries
+}
+
+type summarySeries struct {
+	labelValues []string
+	values      *histogram
+}
+
+// latencyRuns публикует метрики длительностей всех запусков. Метрики удаляются вместе с остальными сериями
+// последнего использующего их запуска.
+type latencyRuns struct {
+	mutex   sync.Mutex
+	metrics map[string]*latencyMetrics
+	keys    map[string]string
+}
+
+var (
+	transactionSummaryDesc = prometheus.NewDesc("runner_transaction_success_duration_summary_seconds",
+		"Квантили времени выполнения успешных транзакций за весь запуск", runLabels("script_name", "step_name"), nil)
+	scenarioSummaryDesc = prometheus.NewDesc("runner_scenario_success_duration_summary_seconds",
+		"Квантили времени выполнения успешных итераций сценариев за весь запуск", runLabels("scenario_name"), nil)
+)
+
+var runLatencies = &latencyRuns{metrics: make(map[string]*latencyMetrics), keys: make(map[string]string)}
+
+func init() {
+	prometheus.MustRegister(runLatencies)
+}
+
+func (options *MetricsOptions) validate() error {
+	if options == nil {
+		return nil
+	}
+	if err := validateBuckets("transactionBuckets", options.TransactionBuckets); err != nil {
+		return err
+	}
+	if err := validateBuckets("scenarioBuckets", options.ScenarioBuckets); err != nil {
+		return err
+	}
+	for _, quantile := range options.Quantiles {
+		if !(quantile > 0 && quantile < 1) {
+			return fmt.Errorf("quantile %v is not in (0, 1)", quantile)
+		}
+	}
+	return nil
+}
+
+// validateBuckets требует конечные и строго возрастающие границы, иначе Prometheus паникует при первом наблюдении
+func validateBuckets(name string, buckets []float64) error {
+	for i, bound := range buckets {
+		if math.IsNaN(bound) || math.IsInf(bound, 0) {
+			return fmt.Errorf("%s: bound %v is not finite", name, bound)
+		}
+		if i > 0 && bound <= buckets[i-1] {
+			return fmt.Errorf("%s must be strictly increasing, got %v after %v", name, bound, buckets[i-1])
+		}
+	}
+	return nil
+}
+
+func newLatencyMetrics(options *MetricsOptions) *latencyMetrics {
+	if options == nil {
+		options = &MetricsOptions{}
+	}
+	transactionBuckets := options.TransactionBuckets
+	if len(transactionBuckets) == 0 {
+		transactionBuckets = prometheus.DefBuckets
+	}
+	scenarioBuckets := options.ScenarioBuckets
+	if len(scenarioBuckets) == 0 {
+		scenarioBuckets = prometheus.DefBuckets
+	}
+
+	metrics := &latencyMetrics{
+		transactionBuckets: transactionBuckets,
+		scenarioBuckets:    scenarioBuckets,
+		runs:               make(map[string]bool),
+		transactionSuccess: prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "runner_transaction_success_duration_seconds",
+			Help: "Время выполнения успешных транзакции", Buckets: transactionBuckets},
+			runLabels("script_name", "step_name")),
+		transactionFailed: prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "runner_transaction_failed_duration_seconds",
+			Help: "Время выполнения неуспешных транзакций", Buckets: transactionBuckets},
+			runLabels("script_name", "step_name", "status_code", "error_class")),
+		transactionPhase: prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "runner_transaction_phase_duration_seconds",
+			Help: "Время этапов запроса: dns, connect, tls, first_byte, body_read", Buckets: transactionBuckets},
+			runLabels("script_name", "step_name", "phase")),
+		scenarioSuccess: prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "runner_scenario_success_duration_seconds",
+			Help: "Время выполнения успешных итераций сценариев", Buckets: scenarioBuckets},
+			runLabels("scenario_name")),
+	}
+	if options.Summary {
+		metrics.quantiles = options.Quantiles
+		if len(metrics.quantiles) == 0 {
+			metrics.quantiles = defaultQuantiles
+		}
+		metrics.transactionSummaries = make(map[string]*summarySeries)
+		metrics.scenarioSummaries = make(map[string]*summarySeries)
+	}
+	return metrics
+}
+
+func (metrics *latencyMetrics) sameLayout(other *latencyMetrics) bool {
+	return equalFloats(metrics.transactionBuckets, other.transactionBuckets) &&
+		equalFloats(metrics.scenarioBuckets, other.scenarioBuckets) && equalFloats(metrics.quantiles, other.quantiles)
+}
+
+func equalFloats(first []float64, second []float64) bool {
+	if len(first) != len(second) {
+		return false
+	}
+	for i := range first {
+		if first[i] != second[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (metrics *latencyMetrics) observeTransaction(duration time.Duration, labelValues ...string) {
+	metrics.transactionSuccess.WithLabelValues(labelValues...).Observe(duration.Seconds())
+	metrics.observeSummary(metrics.transactionSummaries, duration, labelValues)
+}
+
+func (metrics *latencyMetrics) observeFailedTransaction(duration time.Duration, labelValues ...string) {
+	metrics.transactionFailed.WithLabelValues(labelValues...).Observe(duration.Seconds())
+}
+
+func (metrics *latencyMetrics) observePhase(duration time.Duration, labelValues ...string) {
+	metrics.transactionPhase.WithLabelValues(labelValues...).Observe(duration.Seconds())
+}
+
+func (metrics *latencyMetrics) observeScenario(duration time.Duration, labelValues ...string) {
+	metrics.scenarioSuccess.WithLabelValues(labelValues...).Observe(duration.Seconds())
+	metrics.observeSummary(metrics.scenarioSummaries, duration, labelValues)
+}
+
+func (metrics *latencyMetrics) observeSummary(summaries map[string]*summarySeries, duration time.Duration, labelValues []string) {
+	if metrics.quantiles == nil {
+		return
+	}
+	key := strings.Join(labelValues, "\x00")
+	metrics.summaryMutex.Lock()
+	series, exists := summaries[key]
+	if !exists {
+		series = &summarySeries{labelValues: labelValues, values: newHistogram()}
+		summaries[key] = series
+	}
+	metrics.summaryMutex.Unlock()
+	series.values.record(duration, false)
+}
+
+func (metrics *latencyMetrics) collect(ch chan<- prometheus.Metric) {
+	metrics.transactionSuccess.Collect(ch)
+	metrics.transactionFailed.Collect(ch)
+	metrics.transactionPhase.Collect(ch)
+	metrics.scenarioSuccess.Collect(ch)
+	if metrics.quantiles == nil {
+		return
+	}
+
+	metrics.summaryMutex.Lock()
+	defer metrics.summaryMutex.Unlock()
+	for desc, summaries := range map[*prometheus.Desc]map[string]*summarySeries{
+		transactionSummaryDesc: metrics.transactionSummaries, scenarioSummaryDesc: metrics.scenarioSummaries} {
+		for _, series := range summaries {
+			count, sum, values := series.values.snapshot(metrics.quantiles)
+			quantiles := make(map[float64]float64, len(values))
+			for i, value := range values {
+				quantiles[metrics.quantiles[i]] = value.Seconds()
+			}
+			ch <- prometheus.MustNewConstSummary(desc, count, sum.Seconds(), quantiles, series.labelValues...)
+		}
+	}
+}
+
+// add регистрирует метрики запуска. Если метка test_run_id отключена и тест с тем же именем еще выполняется
+// или его серии еще не собраны, запуск использует его метрики, поэтому корзины и квантили должны совпадать.
+func (runs *latencyRuns) add(test *Test) (*latencyMetrics, error) {
+	key := test.Name
+	if metricLabels.testRunId {
+		key = test.Id
+	}
+	requested := newLatencyMetrics(test.Options.Metrics)
+	runs.mutex.Lock()
+	defer runs.mutex.Unlock()
+	metrics, exists := runs.metrics[key]
+	if !exists {
+		metrics = requested
+		runs.metrics[key] = metrics
+	} else if !metrics.sameLayout(requested) {
+		return nil, fmt.Errorf("buckets and quantiles differ from a run of the same test whose series are still published")
+	}
+	metrics.runs[test.Id] = true
+	runs.keys[test.Id] = key
+	return metrics, nil
+}
+
+// remove удаляет метрики, когда их больше не использует ни один запуск
+func (runs *latencyRuns) remove(testRunId string) {
+	runs.mutex.Lock()
+	defer runs.mutex.Unlock()
+	key, exists := runs.keys[testRunId]
+	if !exists {
+		return
+	}
+	delete(runs.keys, testRunId)
+	metrics := runs.metrics[key]
+	delete(metrics.runs, testRunId)
+	if len(metrics.runs) == 0 {
+		delete(runs.metrics, key)
+	}
+}
+
+// Describe ничего не описывает: набор корзин зависит от запуска, поэтому коллектор не проверяется при регистрации
+func (runs *latencyRuns) Describe(chan<- *prometheus.Desc) {}
+
+func (runs *latencyRuns) Collect(ch chan<- prometheus.Metric) {
+	runs.mutex.Lock()
+	defer runs.mutex.Unlock()
+	for _, metrics := range runs.metrics {
+		metrics.collect(ch)
+	}
+}
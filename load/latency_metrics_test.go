@@ -0,0 +1,73 @@
+package load
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLatencyRunsWithoutTestRunId(t *testing.T) {
+	SetMetricLabels(false, "")
+	defer SetMetricLabels(true, "")
+
+	first := &Test{Id: "first-run", Name: "latency", Options: &TestOptions{}}
+	second := &Test{Id: "second-run", Name: "latency", Options: &TestOptions{}}
+	conflicting := &Test{Id: "conflicting-run", Name: "latency",
+		Options: &TestOptions{Metrics: &MetricsOptions{TransactionBuckets: []float64{0.1, 1}}}}
+
+	firstMetrics, err := runLatencies.add(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondMetrics, err := runLatencies.add(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if firstMetrics != secondMetrics {
+		t.Errorf("runs of one test must share series without test_run_id label")
+	}
+	if _, err := runLatencies.add(conflicting); err == nil {
+		t.Errorf("expected error for buckets differing from a running test")
+	}
+
+	deleteRunSeries(map[string]bool{first.Id: true})
+	deleteRunSeries(map[string]bool{first.Id: true})
+	if _, err := runLatencies.add(conflicting); err == nil {
+		t.Errorf("expected error while the second run is published")
+	}
+	deleteRunSeries(map[string]bool{second.Id: true})
+
+	conflictingMetrics, err := runLatencies.add(conflicting)
+	if err != nil {
+		t.Fatalf("buckets of a new run are rejected after previous runs are removed: %v", err)
+	}
+	if conflictingMetrics == firstMetrics {
+		t.Errorf("metrics of removed runs are reused")
+	}
+	deleteRunSeries(map[string]bool{conflicting.Id: true})
+	if _, exists := runLatencies.metrics["latency"]; exists {
+		t.Errorf("metrics are left after all runs are removed")
+	}
+}
+
+func TestMetricsOptionsValidate(t *testing.T) {
+	cases := []struct {
+		options *MetricsOptions
+		valid   bool
+	}{
+		{nil, true},
+		{&MetricsOptions{TransactionBuckets: []float64{0.1, 0.5, 1}, ScenarioBuckets: []float64{1, 10}}, true},
+		{&MetricsOptions{TransactionBuckets: []float64{0.1, 0.1, 1}}, false},
+		{&MetricsOptions{ScenarioBuckets: []float64{1, 1}}, false},
+		{&MetricsOptions{TransactionBuckets: []float64{1, 0.5}}, false},
+		{&MetricsOptions{ScenarioBuckets: []float64{1, math.Inf(1)}}, false},
+		{&MetricsOptions{TransactionBuckets: []float64{math.NaN()}}, false},
+		{&MetricsOptions{Summary: true, Quantiles: []float64{0.5, 0.99}}, true},
+		{&MetricsOptions{Summary: true, Quantiles: []float64{1}}, false},
+		{&MetricsOptions{Summary: true, Quantiles: []float64{math.NaN()}}, false},
+	}
+	for i, c := range cases {
+		if err := c.options.validate(); (err == nil) != c.valid {
+			t.Errorf("case %d: valid %v, got error %v", i, c.valid, err)
+		}
+	}
+}
@@ -1,17 +1,153 @@
 package load
 
 import (
+	"net/http"
+	"sync"
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	testRunIdLabel   = "test_run_id"
+	generatorIdLabel = "generator_id"
 )
 
+// metricLabels задает значения меток запуска. Отключенная метка остается в метриках с пустым значением.
+var metricLabels = struct {
+	testRunId   bool
+	generatorId string
+}{testRunId: true}
+
+// finishedRuns хранит время завершения запусков, серии которых будут удалены после следующего сбора метрик
+var finishedRuns = struct {
+	mutex sync.Mutex
+	ended map[string]time.Time
+}{ended: make(map[string]time.Time)}
+
 var usersCountMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{Name: "runner_users_running", Help: "Текущее количество работающих пользователей"},
-	[]string{"test_name", "scenario_name"})
-var successTransactionCountMetric = promauto.NewHistogramVec(prometheus.HistogramOpts{Name: "runner_transaction_success_duration_seconds", Help: "Время выполнения успешных транзакции"},
-	[]string{"test_name", "script_name", "step_name"})
-var successScenarioCountMetric = promauto.NewHistogramVec(prometheus.HistogramOpts{Name: "runner_scenario_success_duration_seconds", Help: "Время выполнения успешных итераций сценариев"},
-	[]string{"test_name", "scenario_name"})
+	runLabels("scenario_name"))
 var failedTransactionCountMetric = promauto.NewCounterVec(prometheus.CounterOpts{Name: "runner_transaction_failed_count_total", Help: "Число неуспешных транзакций"},
-	[]string{"test_name", "script_name", "step_name", "no_response"})
+	runLabels("script_name", "step_name", "status_code", "error_class", "reason"))
+var sentBytesMetric = promauto.NewCounterVec(prometheus.CounterOpts{Name: "runner_transaction_sent_bytes_total", Help: "Объем отправленных запросов в байтах"},
+	runLabels("script_name", "step_name"))
+var receivedBytesMetric = promauto.NewCounterVec(prometheus.CounterOpts{Name: "runner_transaction_received_bytes_total", Help: "Объем полученных ответов в байтах"},
+	runLabels("script_name", "step_name"))
+var retryTransactionCountMetric = promauto.NewCounterVec(prometheus.CounterOpts{Name: "runner_transaction_retry_count_total", Help: "Число неуспешных попыток транзакций, после которых запрос был повторен"},
+	runLabels("script_name", "step_name", "reason"))
 var failedScenarioCountMetric = promauto.NewCounterVec(prometheus.CounterOpts{Name: "runner_scenario_failed_count_total", Help: "Число неуспешных итераций сценариев"},
-	[]string{"test_name", "scenario_name"})
+	runLabels("scenario_name"))
+var droppedIterationCountMetric = promauto.NewCounterVec(prometheus.CounterOpts{Name: "runner_iterations_dropped_total", Help: "Число итераций, не запущенных из-за нехватки свободных пользователей"},
+	runLabels("scenario_name"))
+var droppedResultCountMetric = promauto.NewCounterVec(prometheus.CounterOpts{Name: "runner_results_dropped_total", Help: "Число результатов запросов, не записанных в файл из-за переполнения буфера"},
+	runLabels())
+
+var runMetrics = []*prometheus.MetricVec{
+	usersCountMetric.MetricVec,
+	failedTransactionCountMetric.MetricVec,
+	sentBytesMetric.MetricVec,
+	receivedBytesMetric.MetricVec,
+	retryTransactionCountMetric.MetricVec,
+	failedScenarioCountMetric.MetricVec,
+	droppedIterationCountMetric.MetricVec,
+	droppedResultCountMetric.MetricVec,
+}
+
+// SetMetricLabels включает метку test_run_id и задает generator_id. Пустой generatorId отключает метку.
+func SetMetricLabels(withTestRunId bool, generatorId string) {
+	metricLabels.testRunId = withTestRunId
+	metricLabels.generatorId = generatorId
+}
+
+func runLabels(names ...string) []string {
+	return append([]string{"test_name", testRunIdLabel, generatorIdLabel}, names...)
+}
+
+func runLabelValues(testName string, testRunId string, values ...string) []string {
+	if !metricLabels.testRunId {
+		testRunId = ""
+	}
+	return append([]string{testName, testRunId, metricLabels.generatorId}, values...)
+}
+
+// markRunFinished откладывает удаление серий запуска до следующего сбора метрик, чтобы итоговые значения
+// успели попасть в Prometheus
+func markRunFinished(testRunId string) {
+	finishedRuns.mutex.Lock()
+	finishedRuns.ended[testRunId] = time.Now()
+	finishedRuns.mutex.Unlock()
+}
+
+// MetricsHandler отдает метрики и после каждого сбора удаляет серии запусков, завершившихся до его начала
+func MetricsHandler() http.Handler {
+	handler := promhttp.Handler()
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		scrapeStart := time.Now()
+		handler.ServeHTTP(writer, request)
+		deleteScrapedRuns(scrapeStart)
+	})
+}
+
+func deleteScrapedRuns(scrapeStart time.Time) {
+	finishedRuns.mutex.Lock()
+	scraped := make(map[string]bool)
+	for testRunId, ended := range finishedRuns.ended {
+		if ended.Before(scrapeStart) {
+			scraped[testRunId] = true
+			delete(finishedRuns.ended, testRunId)
+		}
+	}
+	finishedRuns.mutex.Unlock()
+	if len(scraped) > 0 {
+		deleteRunSeries(scraped)
+	}
+}
+
+func deleteRunSeries(testRunIds map[string]bool) {
+	finishedRuns.mutex.Lock()
+	for testRunId := range testRunIds {
+		delete(finishedRuns.ended, testRunId)
+	}
+	finishedRuns.mutex.Unlock()
+
+	for testRunId := range testRunIds {
+		runLatencies.remove(testRunId)
+	}
+	// без метки test_run_id остальные серии общие для всех запусков теста и не удаляются
+	if !metricLabels.testRunId {
+		return
+	}
+	for _, vec := range runMetrics {
+		for _, labels := range seriesLabels(vec) {
+			if testRunIds[labels[testRunIdLabel]] {
+				vec.Delete(labels)
+			}
+		}
+	}
+}
+
+// seriesLabels возвращает метки всех серий вектора метрик
+func seriesLabels(vec *prometheus.MetricVec) []prometheus.Labels {
+	metrics := make(chan prometheus.Metric)
+	go func() {
+		vec.Collect(metrics)
+		close(metrics)
+	}()
+
+	var result []prometheus.Labels
+	for metric := range metrics {
+		var written dto.Metric
+		if err := metric.Write(&written); err != nil {
+			continue
+		}
+		labels := make(prometheus.Labels, len(written.Label))
+		for _, pair := range written.Label {
+			labels[pair.GetName()] = pair.GetValue()
+		}
+		result = append(result, labels)
+	}
+	return result
+}
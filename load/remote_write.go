@@ -0,0 +1,242 @@
+package load
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const runnerMetricPrefix = "runner_"
+
+// RemoteWriteOptions задает адрес Prometheus remote-write и авторизацию: BearerToken или Username и Password
+type RemoteWriteOptions struct {
+	Url         string
+	Interval    time.Duration
+	BearerToken string
+	Username    string
+	Password    string
+}
+
+type RemoteWriteClient struct {
+	options *RemoteWriteOptions
+	client  *http.Client
+}
+
+// remotePusher отправляет серии одного запуска, пока он выполняется
+type remotePusher struct {
+	client    *RemoteWriteClient
+	testRunId string
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+type timeSeries struct {
+	labels []*dto.LabelPair
+	value  float64
+}
+
+var remoteWrite *RemoteWriteClient
+
+func NewRemoteWriteClient(options *RemoteWriteOptions) *RemoteWriteClient {
+	return &RemoteWriteClient{options: options, client: &http.Client{Timeout: options.Interval}}
+}
+
+// SetRemoteWrite включает отправку метрик runner_* во время тестов, вызывается до запуска тестов
+func SetRemoteWrite(client *RemoteWriteClient) {
+	remoteWrite = client
+}
+
+// startPushing каждые Interval отправляет серии запуска. Если отправка не настроена, возвращает nil.
+func (client *RemoteWriteClient) startPushing(testRunId string) *remotePusher {
+	if client == nil {
+		return nil
+	}
+	pusher := &remotePusher{client: client, testRunId: testRunId, stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(pusher.done)
+		ticker := time.NewTicker(client.options.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				pusher.push()
+			case <-pusher.stop:
+				return
+			}
+		}
+	}()
+	return pusher
+}
+
+// stopPushing последний раз отправляет итоговые значения. После успешной отправки серии запуска удаляются,
+// не дожидаясь сбора метрик через /metrics.
+func (pusher *remotePusher) stopPushing() {
+	if pusher == nil {
+		return
+	}
+	close(pusher.stop)
+	<-pusher.done
+	if pusher.push() {
+		deleteRunSeries(map[string]bool{pusher.testRunId: true})
+	}
+}
+
+func (pusher *remotePusher) push() bool {
+	series, err := runSeries(pusher.testRunId)
+	if err == nil && len(series) > 0 {
+		err = pusher.client.send(series, time.Now())
+	}
+	if err != nil {
+		log.Error().Err(err).Str("testRunId", pusher.testRunId).Msg("Не удалось отправить метрики через remote-write")
+		return false
+	}
+	return true
+}
+
+// runSeries собирает серии runner_* запуска. Если метка test_run_id отключена, отправляются серии всех запусков.
+func runSeries(testRunId string) ([]*timeSeries, error) {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		return nil, err
+	}
+
+	var series []*timeSeries
+	for _, family := range families {
+		if !strings.HasPrefix(family.GetName(), runnerMetricPrefix) {
+			continue
+		}
+		for _, metric := range family.Metric {
+			if metricLabels.testRunId && labelValue(metric.Label, testRunIdLabel) != testRunId {
+				continue
+			}
+			series = append(series, metricSeries(family.GetName(), family.GetType(), metric)...)
+		}
+	}
+	return series, nil
+}
+
+// metricSeries раскладывает метрику на серии так же, как их видит Prometheus при сборе: гистограмма
+// превращается в _bucket, _sum и _count, summary - в квантили, _sum и _count
+func metricSeries(name string, metricType dto.MetricType, metric *dto.Metric) []*timeSeries {
+	newSeries := func(suffix string, value float64, extra ...*dto.LabelPair) *timeSeries {
+		labels := append([]*dto.LabelPair{namePair("__name__", name+suffix)}, metric.Label...)
+		return &timeSeries{labels: append(labels, extra...), value: value}
+	}
+
+	switch metricType {
+	case dto.MetricType_COUNTER:
+		return []*timeSeries{newSeries("", metric.GetCounter().GetValue())}
+	case dto.MetricType_GAUGE:
+		return []*timeSeries{newSeries("", metric.GetGauge().GetValue())}
+	case dto.MetricType_HISTOGRAM:
+		histogram := metric.GetHistogram()
+		var series []*timeSeries
+		for _, bucket := range histogram.Bucket {
+			if math.IsInf(bucket.GetUpperBound(), 1) {
+				continue
+			}
+			series = append(series, newSeries("_bucket", float64(bucket.GetCumulativeCount()),
+				namePair("le", formatFloat(bucket.GetUpperBound()))))
+		}
+		return append(series,
+			newSeries("_bucket", float64(histogram.GetSampleCount()), namePair("le", "+Inf")),
+			newSeries("_sum", histogram.GetSampleSum()),
+			newSeries("_count", float64(histogram.GetSampleCount())))
+	case dto.MetricType_SUMMARY:
+		summary := metric.GetSummary()
+		var series []*timeSeries
+		for _, quantile := range summary.Quantile {
+			series = append(series, newSeries("", quantile.GetValue(),
+				namePair("quantile", formatFloat(quantile.GetQuantile()))))
+		}
+		return append(series,
+			newSeries("_sum", summary.GetSampleSum()),
+			newSeries("_count", float64(summary.GetSampleCount())))
+	default:
+		return []*timeSeries{newSeries("", metric.GetUntyped().GetValue())}
+	}
+}
+
+func (client *RemoteWriteClient) send(series []*timeSeries, now time.Time) error {
+	body := snappy.Encode(nil, encodeWriteRequest(series, now.UnixMilli()))
+	req, err := http.NewRequest(http.MethodPost, client.options.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if client.options.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+client.options.BearerToken)
+	} else if client.options.Username != "" {
+		req.SetBasicAuth(client.options.Username, client.options.Password)
+	}
+
+	resp, err := client.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("remote write responded with status %d: %s", resp.StatusCode, message)
+	}
+	return nil
+}
+
+// encodeWriteRequest кодирует prometheus.WriteRequest: timeseries = 1, в TimeSeries labels = 1 и samples = 2,
+// в Label name = 1 и value = 2, в Sample value = 1 и timestamp = 2
+func encodeWriteRequest(series []*timeSeries, timestamp int64) []byte {
+	var request []byte
+	for _, item := range series {
+		sort.Slice(item.labels, func(i, j int) bool { return item.labels[i].GetName() < item.labels[j].GetName() })
+		var encoded []byte
+		for _, label := range item.labels {
+			if label.GetValue() == "" {
+				continue
+			}
+			var pair []byte
+			pair = protowire.AppendTag(pair, 1, protowire.BytesType)
+			pair = protowire.AppendString(pair, label.GetName())
+			pair = protowire.AppendTag(pair, 2, protowire.BytesType)
+			pair = protowire.AppendString(pair, label.GetValue())
+			encoded = protowire.AppendTag(encoded, 1, protowire.BytesType)
+			encoded = protowire.AppendBytes(encoded, pair)
+		}
+		var sample []byte
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(item.value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(timestamp))
+		encoded = protowire.AppendTag(encoded, 2, protowire.BytesType)
+		encoded = protowire.AppendBytes(encoded, sample)
+
+		request = protowire.AppendTag(request, 1, protowire.BytesType)
+		request = protowire.AppendBytes(request, encoded)
+	}
+	return request
+}
+
+func namePair(name string, value string) *dto.LabelPair {
+	return &dto.LabelPair{Name: &name, Value: &value}
+}
+
+func labelValue(labels []*dto.LabelPair, name string) string {
+	for _, label := range labels {
+		if label.GetName() == name {
+			return label.GetValue()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,166 @@
+package load
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+type decodedSample struct {
+	labels    []string
+	value     float64
+	timestamp int64
+}
+
+// decodeWriteRequest разбирает prometheus.WriteRequest, метки серии возвращаются в виде name=value
+func decodeWriteRequest(t *testing.T, data []byte) []decodedSample {
+	t.Helper()
+	consumeFields := func(data []byte, field func(number protowire.Number, typ protowire.Type, data []byte) int) {
+		for len(data) > 0 {
+			number, typ, n := protowire.ConsumeTag(data)
+			if n < 0 {
+				t.Fatalf("invalid tag: %v", protowire.ParseError(n))
+			}
+			data = data[n:]
+			n = field(number, typ, data)
+			if n < 0 {
+				t.Fatalf("invalid field %d: %v", number, protowire.ParseError(n))
+			}
+			data = data[n:]
+		}
+	}
+
+	var samples []decodedSample
+	consumeFields(data, func(number protowire.Number, typ protowire.Type, data []byte) int {
+		series, n := protowire.ConsumeBytes(data)
+		if number != 1 || typ != protowire.BytesType {
+			t.Fatalf("unexpected write request field %d of type %d", number, typ)
+		}
+		sample := decodedSample{}
+		consumeFields(series, func(number protowire.Number, typ protowire.Type, data []byte) int {
+			message, n := protowire.ConsumeBytes(data)
+			switch number {
+			case 1:
+				var name, value string
+				consumeFields(message, func(number protowire.Number, typ protowire.Type, data []byte) int {
+					text, n := protowire.ConsumeString(data)
+					if number == 1 {
+						name = text
+					} else {
+						value = text
+					}
+					return n
+				})
+				sample.labels = append(sample.labels, name+"="+value)
+			case 2:
+				consumeFields(message, func(number protowire.Number, typ protowire.Type, data []byte) int {
+					if number == 1 {
+						bits, n := protowire.ConsumeFixed64(data)
+						sample.value = math.Float64frombits(bits)
+						return n
+					}
+					timestamp, n := protowire.ConsumeVarint(data)
+					sample.timestamp = int64(timestamp)
+					return n
+				})
+			default:
+				t.Fatalf("unexpected time series field %d", number)
+			}
+			return n
+		})
+		samples = append(samples, sample)
+		return n
+	})
+	return samples
+}
+
+func TestRemoteWriteRequest(t *testing.T) {
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "runner_duration_seconds", Buckets: []float64{0.1, 1},
+		ConstLabels: prometheus.Labels{"test_name": "checkout", "test_run_id": "run", "generator_id": ""}})
+	histogram.Observe(0.05)
+	histogram.Observe(0.5)
+	histogram.Observe(2)
+	var histogramMetric dto.Metric
+	if err := histogram.Write(&histogramMetric); err != nil {
+		t.Fatal(err)
+	}
+	summary := prometheus.NewSummary(prometheus.SummaryOpts{Name: "runner_latency_seconds",
+		Objectives: map[float64]float64{0.5: 0.01}, ConstLabels: prometheus.Labels{"test_name": "checkout"}})
+	summary.Observe(3)
+	var summaryMetric dto.Metric
+	if err := summary.Write(&summaryMetric); err != nil {
+		t.Fatal(err)
+	}
+	series := append(metricSeries("runner_duration_seconds", dto.MetricType_HISTOGRAM, &histogramMetric),
+		metricSeries("runner_latency_seconds", dto.MetricType_SUMMARY, &summaryMetric)...)
+
+	requests := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- r
+		bodies <- body
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	client := NewRemoteWriteClient(&RemoteWriteOptions{Url: receiver.URL, Interval: time.Second, BearerToken: "secret"})
+	now := time.UnixMilli(1700000000123)
+	if err := client.send(series, now); err != nil {
+		t.Fatal(err)
+	}
+
+	request := <-requests
+	for header, expected := range map[string]string{"Content-Encoding": "snappy", "Content-Type": "application/x-protobuf",
+		"X-Prometheus-Remote-Write-Version": "0.1.0", "Authorization": "Bearer secret"} {
+		if value := request.Header.Get(header); value != expected {
+			t.Errorf("header %s: got %q, expected %q", header, value, expected)
+		}
+	}
+	data, err := snappy.Decode(nil, <-bodies)
+	if err != nil {
+		t.Fatalf("body is not snappy encoded: %v", err)
+	}
+
+	expected := []struct {
+		labels string
+		value  float64
+	}{
+		{"__name__=runner_duration_seconds_bucket,le=0.1,test_name=checkout,test_run_id=run", 1},
+		{"__name__=runner_duration_seconds_bucket,le=1,test_name=checkout,test_run_id=run", 2},
+		{"__name__=runner_duration_seconds_bucket,le=+Inf,test_name=checkout,test_run_id=run", 3},
+		{"__name__=runner_duration_seconds_sum,test_name=checkout,test_run_id=run", 2.55},
+		{"__name__=runner_duration_seconds_count,test_name=checkout,test_run_id=run", 3},
+		{"__name__=runner_latency_seconds,quantile=0.5,test_name=checkout", 3},
+		{"__name__=runner_latency_seconds_sum,test_name=checkout", 3},
+		{"__name__=runner_latency_seconds_count,test_name=checkout", 1},
+	}
+	samples := decodeWriteRequest(t, data)
+	if len(samples) != len(expected) {
+		t.Fatalf("got %d series, expected %d", len(samples), len(expected))
+	}
+	for i, sample := range samples {
+		if !sort.StringsAreSorted(sample.labels) {
+			t.Errorf("series %d: labels %v are not sorted", i, sample.labels)
+		}
+		if labels := strings.Join(sample.labels, ","); labels != expected[i].labels {
+			t.Errorf("series %d: got labels %s, expected %s", i, labels, expected[i].labels)
+		}
+		if math.Abs(sample.value-expected[i].value) > 1e-9 {
+			t.Errorf("series %d: got value %v, expected %v", i, sample.value, expected[i].value)
+		}
+		if sample.timestamp != now.UnixMilli() {
+			t.Errorf("series %d: got timestamp %d, expected %d", i, sample.timestamp, now.UnixMilli())
+		}
+	}
+}
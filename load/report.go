@@ -0,0 +1,135 @@
+package load
+
+import (
+	"time"
+)
+
+// Summary - итоговая статистика транзакций или итераций. Длительности в миллисекундах,
+// Throughput - число в секунду за все время теста.
+type Summary struct {
+	Count      uint64  `json:"count"`
+	Failed     uint64  `json:"failed"`
+	ErrorRate  float64 `json:"errorRate"`
+	Min        float64 `json:"min"`
+	Avg        float64 `json:"avg"`
+	P50        float64 `json:"p50"`
+	P90        float64 `json:"p90"`
+	P95        float64 `json:"p95"`
+	P99        float64 `json:"p99"`
+	Max        float64 `json:"max"`
+	Throughput float64 `json:"throughput"`
+}
+
+type Report struct {
+	TestId       string            `json:"testId"`
+	TestName     string            `json:"testName"`
+	Start        time.Time         `json:"start"`
+	End          time.Time         `json:"end"`
+	Duration     float64           `json:"duration"`
+	Finished     bool              `json:"finished"`
+	Transactions *Summary          `json:"transactions"`
+	Setup        *ScriptReport     `json:"setup,omitempty"`
+	Teardown     *ScriptReport     `json:"teardown,omitempty"`
+	Scenarios    []*ScenarioReport `json:"scenarios"`
+}
+
+type ScenarioReport struct {
+	Name       string          `json:"name"`
+	Iterations *Summary        `json:"iterations"`
+	Scripts    []*ScriptReport `json:"scripts"`
+}
+
+type ScriptReport struct {
+	Name       string        `json:"name"`
+	Iterations *Summary      `json:"iterations"`
+	Steps      []*StepReport `json:"steps"`
+}
+
+type StepReport struct {
+	Name         string   `json:"name"`
+	Transactions *Summary `json:"transactions"`
+}
+
+// prepareReport заводит гистограммы для сценариев, скриптов и шагов с запросами
+func (test *Test) prepareReport() {
+	for _, script := range test.scripts() {
+		script.stats = newHistogram()
+		_ = forEachStep(script.Steps, func(step *Step) error {
+			step.stats = newHistogram()
+			return nil
+		})
+	}
+	for _, scenario := range test.Scenarios {
+		scenario.stats = newHistogram()
+	}
+}
+
+// Report собирает отчет по текущим результатам теста, до завершения теста длительность считается до текущего момента
+func (test *Test) Report() *Report {
+	test.timeMutex.Lock()
+	start, end := test.startTime, test.endTime
+	test.timeMutex.Unlock()
+	finished := !end.IsZero()
+	if !finished {
+		end = time.Now()
+	}
+	elapsed := end.Sub(start)
+	if start.IsZero() {
+		elapsed = 0
+	}
+
+	report := &Report{
+		TestId:    test.Id,
+		TestName:  test.Name,
+		Start:     start,
+		End:       end,
+		Duration:  elapsed.Seconds(),
+		Finished:  finished,
+		Scenarios: make([]*ScenarioReport, 0, len(test.Scenarios)),
+	}
+	transactions := newHistogram()
+	scriptReport := func(script *Script) *ScriptReport {
+		if script == nil {
+			return nil
+		}
+		result := &ScriptReport{Name: script.Name, Iterations: script.stats.summary(elapsed)}
+		_ = forEachStep(script.Steps, func(step *Step) error {
+			if step.hasRequest() {
+				result.Steps = append(result.Steps, &StepReport{Name: step.Name, Transactions: step.stats.summary(elapsed)})
+				transactions.merge(step.stats)
+			}
+			return nil
+		})
+		return result
+	}
+
+	report.Setup = scriptReport(test.Setup)
+	for _, scenario := range test.Scenarios {
+		scenarioReport := &ScenarioReport{Name: scenario.Name, Iterations: scenario.stats.summary(elapsed)}
+		for _, script := range scenario.userScripts {
+			scenarioReport.Scripts = append(scenarioReport.Scripts, scriptReport(script))
+		}
+		report.Scenarios = append(report.Scenarios, scenarioReport)
+	}
+	report.Teardown = scriptReport(test.Teardown)
+	report.Transactions = transactions.summary(elapsed)
+	return report
+}
+
+// scripts возвращает все скрипты теста, включая setup, teardown и скрипты пользователей сценариев
+func (test *Test) scripts() []*Script {
+	var scripts []*Script
+	for _, script := range []*Script{test.Setup, test.Teardown} {
+		if script != nil {
+			scripts = append(scripts, script)
+		}
+	}
+	for _, scenario := range test.Scenarios {
+		scripts = append(scripts, scenario.userScripts...)
+	}
+	return scripts
+}
+
+func (step *Step) hasRequest() bool {
+	return len(step.Steps) == 0 && len(step.Branches) == 0
+}
@@ -0,0 +1,56 @@
+package load
+
+import (
+	"html/template"
+	"io"
+)
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"percent": func(value float64) float64 { return value * 100 },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Отчет по тесту {{.TestName}}</title>
+<style>
+body { font-family: sans-serif; margin: 24px; color: #222; }
+table { border-collapse: collapse; margin-bottom: 24px; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+th { background: #f0f0f0; }
+tr.script td { background: #fafafa; font-weight: bold; }
+td.failed { color: #c00; }
+</style>
+</head>
+<body>
+<h1>Отчет по тесту {{.TestName}}</h1>
+<p>Id: {{.TestId}}<br>
+Начало: {{.Start.Format "2006-01-02 15:04:05"}}<br>
+Длительность: {{printf "%.1f" .Duration}} с{{if not .Finished}} (тест еще выполняется){{end}}</p>
+{{define "header"}}<tr><th>Имя</th><th>Всего</th><th>Ошибок</th><th>Ошибки, %</th><th>Min, мс</th><th>Avg, мс</th>
+<th>p50, мс</th><th>p90, мс</th><th>p95, мс</th><th>p99, мс</th><th>Max, мс</th><th>В секунду</th></tr>{{end}}
+{{define "summary"}}<td>{{.Count}}</td><td{{if .Failed}} class="failed"{{end}}>{{.Failed}}</td>
+<td>{{printf "%.2f" (percent .ErrorRate)}}</td><td>{{printf "%.1f" .Min}}</td><td>{{printf "%.1f" .Avg}}</td>
+<td>{{printf "%.1f" .P50}}</td><td>{{printf "%.1f" .P90}}</td><td>{{printf "%.1f" .P95}}</td>
+<td>{{printf "%.1f" .P99}}</td><td>{{printf "%.1f" .Max}}</td><td>{{printf "%.2f" .Throughput}}</td>{{end}}
+{{define "script"}}<tr class="script"><td>{{.Name}}</td>{{template "summary" .Iterations}}</tr>
+{{range .Steps}}<tr><td>&nbsp;&nbsp;{{.Name}}</td>{{template "summary" .Transactions}}</tr>
+{{end}}{{end}}
+<h2>Все транзакции</h2>
+<table>{{template "header"}}<tr><td>{{.TestName}}</td>{{template "summary" .Transactions}}</tr></table>
+{{if .Setup}}<h2>Подготовка теста</h2>
+<table>{{template "header"}}{{template "script" .Setup}}</table>{{end}}
+{{range .Scenarios}}<h2>Сценарий {{.Name}}</h2>
+<table>{{template "header"}}<tr class="script"><td>Итерации сценария</td>{{template "summary" .Iterations}}</tr>
+{{range .Scripts}}{{template "script" .}}{{end}}</table>
+{{end}}
+{{if .Teardown}}<h2>Завершение теста</h2>
+<table>{{template "header"}}{{template "script" .Teardown}}</table>{{end}}
+</body>
+</html>
+`))
+
+// WriteHtml выводит отчет в виде самодостаточной HTML страницы
+func (report *Report) WriteHtml(writer io.Writer) error {
+	return reportTemplate.Execute(writer, report)
+}
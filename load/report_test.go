@@ -0,0 +1,79 @@
+package load
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestReportAggregatesNestedSteps(t *testing.T) {
+	request := func(name string) *Step {
+		return &Step{Name: name, Url: "http://localhost", Method: http.MethodGet}
+	}
+	script := &Script{Name: "shop", Steps: []*Step{
+		request("open"),
+		{Name: "browse", Steps: []*Step{request("search"), request("view")}},
+		{Name: "checkout", Branches: []*Branch{
+			{Weight: 1, Steps: []*Step{request("pay by card")}},
+			{Weight: 1, Steps: []*Step{{Name: "nested", Steps: []*Step{request("pay by invoice")}}}},
+		}},
+	}}
+	setup := &Script{Name: "login", Steps: []*Step{request("log in")}}
+	scenario := &Scenario{Name: "buyers", Script: script, Steps: []ScenarioStep{
+		{Action: StartAction, TotalUsersCount: 1, CountUsersByPeriod: 1},
+		{Action: DurationAction, Period: 1},
+	}}
+	test := &Test{Id: "report-run", Name: "report", Setup: setup, Options: &TestOptions{}, Scenarios: []*Scenario{scenario}}
+	if err := test.PrepareTest(); err != nil {
+		t.Fatal(err)
+	}
+
+	record := func(step *Step, failed bool, durations ...time.Duration) {
+		for _, duration := range durations {
+			step.stats.record(duration, failed)
+		}
+	}
+	record(setup.Steps[0], false, 5*time.Millisecond)
+	record(script.Steps[0], false, 10*time.Millisecond, 20*time.Millisecond)
+	record(script.Steps[1].Steps[1], true, 30*time.Millisecond)
+	record(script.Steps[2].Branches[0].Steps[0], false, 40*time.Millisecond)
+	record(script.Steps[2].Branches[1].Steps[0].Steps[0], true, 500*time.Millisecond)
+	scenario.stats.record(time.Second, true)
+	script.stats.record(time.Second, true)
+
+	report := test.Report()
+	if report.Finished || report.Duration != 0 {
+		t.Errorf("not started test is reported as finished %v with duration %v", report.Finished, report.Duration)
+	}
+	if report.Transactions.Count != 6 || report.Transactions.Failed != 2 || report.Transactions.Min != 5 ||
+		report.Transactions.Max != 500 {
+		t.Errorf("unexpected transactions %+v", report.Transactions)
+	}
+	if report.Setup == nil || len(report.Setup.Steps) != 1 || report.Setup.Steps[0].Transactions.Count != 1 {
+		t.Errorf("unexpected setup report %+v", report.Setup)
+	}
+	if report.Teardown != nil {
+		t.Errorf("unexpected teardown report %+v", report.Teardown)
+	}
+	if len(report.Scenarios) != 1 || report.Scenarios[0].Iterations.Failed != 1 || len(report.Scenarios[0].Scripts) != 1 {
+		t.Fatalf("unexpected scenarios %+v", report.Scenarios)
+	}
+
+	steps := report.Scenarios[0].Scripts[0].Steps
+	expected := []struct {
+		name  string
+		count uint64
+	}{{"open", 2}, {"search", 0}, {"view", 1}, {"pay by card", 1}, {"pay by invoice", 1}}
+	if len(steps) != len(expected) {
+		t.Fatalf("got %d step reports, expected only requests %v", len(steps), expected)
+	}
+	for i, step := range steps {
+		if step.Name != expected[i].name || step.Transactions.Count != expected[i].count {
+			t.Errorf("step %d: got %s with %d transactions, expected %s with %d", i, step.Name,
+				step.Transactions.Count, expected[i].name, expected[i].count)
+		}
+	}
+	if open := steps[0].Transactions; open.Avg != 15 || open.Min != 10 || open.Max != 20 {
+		t.Errorf("unexpected open step summary %+v", open)
+	}
+}
@@ -0,0 +1,205 @@
+package load
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/ledokol-inc/ledokol/load/variables"
+)
+
+type ResultsFormat string
+
+const (
+	JsonlResults ResultsFormat = "jsonl"
+	CsvResults   ResultsFormat = "csv"
+)
+
+const (
+	defaultResultsBufferSize = 10000
+	resultsFlushInterval     = time.Second
+)
+
+var resultsCsvHeader = []string{"timestamp", "testId", "scenario", "script", "step", "userId", "iterationId",
+	"requestId", "status", "latency", "sentBytes", "receivedBytes", "error"}
+
+// ResultsOptions включает запись результата каждого запроса в файл внутри каталога результатов. По умолчанию файл
+// называется <id теста>.<формат>, а формат определяется по расширению файла. Если буфер из BufferSize записей заполнен,
+// новые записи отбрасываются, чтобы не замедлять пользователей.
+type ResultsOptions struct {
+	File       string
+	Format     ResultsFormat
+	BufferSize int
+}
+
+// requestResult - запись о выполнении одного запроса, Latency в миллисекундах
+type requestResult struct {
+	Timestamp     time.Time `json:"timestamp"`
+	TestId        string    `json:"testId"`
+	Scenario      string    `json:"scenario"`
+	Script        string    `json:"script"`
+	Step          string    `json:"step"`
+	UserId        string    `json:"userId"`
+	IterationId   string    `json:"iterationId"`
+	RequestId     string    `json:"requestId"`
+	Status        int       `json:"status"`
+	Latency       float64   `json:"latency"`
+	SentBytes     int       `json:"sentBytes"`
+	ReceivedBytes int       `json:"receivedBytes"`
+	Error         string    `json:"error,omitempty"`
+}
+
+var resultsDirectory = "."
+
+// SetResultsDirectory задает каталог, в котором создаются файлы результатов, вызывается до запуска тестов
+func SetResultsDirectory(directory string) {
+	resultsDirectory = directory
+}
+
+type resultWriter struct {
+	testName string
+	format   ResultsFormat
+	file     *os.File
+	buffer   *bufio.Writer
+	csv      *csv.Writer
+	records  chan *requestResult
+	done     chan struct{}
+}
+
+func newResultWriter(options *ResultsOptions, testId string, testName string) (*resultWriter, error) {
+	format := options.Format
+	if format == "" && options.File != "" {
+		format = ResultsFormat(strings.TrimPrefix(filepath.Ext(options.File), "."))
+	}
+	if format == "" {
+		format = JsonlResults
+	}
+	if format != JsonlResults && format != CsvResults {
+		return nil, fmt.Errorf("unknown results format %q", format)
+	}
+	fileName := options.File
+	if fileName == "" {
+		fileName = testId + "." + string(format)
+	}
+	bufferSize := options.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = defaultResultsBufferSize
+	}
+
+	path, err := variables.ResolveFile(resultsDirectory, fileName)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	writer := &resultWriter{
+		testName: testName,
+		format:   format,
+		file:     file,
+		buffer:   bufio.NewWriter(file),
+		records:  make(chan *requestResult, bufferSize),
+		done:     make(chan struct{}),
+	}
+	if format == CsvResults {
+		writer.csv = csv.NewWriter(writer.buffer)
+		if err := writer.csv.Write(resultsCsvHeader); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	go writer.run()
+	return writer, nil
+}
+
+// add передает запись в буфер без ожидания. Для nil писателя (запись результатов не включена) ничего не делает.
+func (writer *resultWriter) add(record *requestResult) {
+	if writer == nil {
+		return
+	}
+	select {
+	case writer.records <- record:
+	default:
+		droppedResultCountMetric.WithLabelValues(runLabelValues(writer.testName, record.TestId)...).Inc()
+		emit(CounterEvent, "results_dropped", 1, "test_name", writer.testName, "test_run_id", record.TestId)
+	}
+}
+
+func (writer *resultWriter) run() {
+	defer close(writer.done)
+	ticker := time.NewTicker(resultsFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case record, ok := <-writer.records:
+			if !ok {
+				return
+			}
+			if err := writer.write(record); err != nil {
+				log.Error().Err(err).Str("file", writer.file.Name()).Msg("Не удалось записать результат запроса")
+			}
+		case <-ticker.C:
+			writer.flush()
+		}
+	}
+}
+
+func (writer *resultWriter) write(record *requestResult) error {
+	if writer.format == JsonlResults {
+		line, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		writer.buffer.Write(line)
+		return writer.buffer.WriteByte('\n')
+	}
+	return writer.csv.Write([]string{
+		record.Timestamp.Format(time.RFC3339Nano),
+		record.TestId,
+		record.Scenario,
+		record.Script,
+		record.Step,
+		record.UserId,
+		record.IterationId,
+		record.RequestId,
+		strconv.Itoa(record.Status),
+		strconv.FormatFloat(record.Latency, 'f', 3, 64),
+		strconv.Itoa(record.SentBytes),
+		strconv.Itoa(record.ReceivedBytes),
+		record.Error,
+	})
+}
+
+func (writer *resultWriter) flush() {
+	if writer.csv != nil {
+		writer.csv.Flush()
+	}
+	if err := writer.buffer.Flush(); err != nil {
+		log.Error().Err(err).Str("file", writer.file.Name()).Msg("Не удалось записать результаты в файл")
+	}
+}
+
+// close дописывает оставшиеся записи и закрывает файл, вызывается после остановки всех пользователей
+func (writer *resultWriter) close() {
+	if writer == nil {
+		return
+	}
+	close(writer.records)
+	<-writer.done
+	writer.flush()
+	if err := writer.file.Close(); err != nil {
+		log.Error().Err(err).Str("file", writer.file.Name()).Msg("Не удалось закрыть файл результатов")
+	}
+}
@@ -0,0 +1,43 @@
+package load
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResultWriterStaysInResultsDirectory(t *testing.T) {
+	directory := t.TempDir()
+	SetResultsDirectory(directory)
+	defer SetResultsDirectory(".")
+
+	for _, c := range []struct {
+		options *ResultsOptions
+		testId  string
+	}{
+		{&ResultsOptions{File: "../config.yaml"}, "run"},
+		{&ResultsOptions{File: filepath.Join(directory, "abs.jsonl")}, "run"},
+		{&ResultsOptions{Format: CsvResults}, "../../config"},
+	} {
+		if writer, err := newResultWriter(c.options, c.testId, "test"); err == nil {
+			writer.close()
+			t.Errorf("%+v, test id %s: expected error", c.options, c.testId)
+		}
+	}
+
+	writer, err := newResultWriter(&ResultsOptions{Format: CsvResults}, "runs/first", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.add(&requestResult{TestId: "runs/first", Step: "get", Status: 200})
+	writer.close()
+
+	content, err := os.ReadFile(filepath.Join(directory, "runs", "first.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(content)), "\n"); len(lines) != 2 {
+		t.Errorf("expected header and one record, got %q", content)
+	}
+}
@@ -1,9 +1,14 @@
 package load
 
 import (
+	"errors"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/ledokol-inc/ledokol/load/variables"
 )
 
 type StepAction string
@@ -12,29 +17,72 @@ const (
 	StartAction    StepAction = "start"
 	DurationAction StepAction = "duration"
 	StopAction     StepAction = "stop"
+	RateAction     StepAction = "rate"
+)
+
+type Executor string
+
+const (
+	UsersExecutor             Executor = "users"
+	ArrivalRateExecutor       Executor = "arrivalRate"
+	SharedIterationsExecutor  Executor = "sharedIterations"
+	PerUserIterationsExecutor Executor = "perUserIterations"
 )
 
 type Scenario struct {
 	Name                string
-	stopUserChannel     chan struct{}
 	Steps               []ScenarioStep
 	Pacing              float64
 	PacingDelta         float64
 	Script              *Script
+	Scripts             []*Script
+	scripts             []*Script
+	scriptWeightsSum    float64
+	OnStart             *Script `mapstructure:"onStart" json:"onStart"`
+	OnStop              *Script `mapstructure:"onStop" json:"onStop"`
+	userScripts         []*Script
 	stopScenarioChannel chan struct{}
 	stopped             atomic.Bool
 	runningUserWait     *sync.WaitGroup
+	test                *Test
+	Executor            Executor
+	MaxUsers            int
+	Users               int
+	Iterations          int
+	remainingIterations int64
+	maxDuration         float64
+	stats               *histogram
+	latency             *latencyMetrics
+	runningUsers        int64
+	usersMutex          sync.Mutex
+	liveUsers           map[*User]bool
+	usersStopped        bool
 }
 
+// ScenarioStep для users исполнителя запускает или останавливает пользователей, для arrivalRate
+// шаг rate линейно меняет частоту запуска итераций до Rate (итераций в секунду) за Period секунд.
 type ScenarioStep struct {
 	Action             StepAction
 	TotalUsersCount    int
 	CountUsersByPeriod int
 	Period             float64
+	Rate               float64
 }
 
-func (scenario *Scenario) PrepareScenario(totalDuration float64) {
-	if totalDuration != 0 {
+func (scenario *Scenario) PrepareScenario(totalDuration float64) error {
+	if err := scenario.prepareScripts(); err != nil {
+		return err
+	}
+
+	if scenario.Executor == ArrivalRateExecutor {
+		if err := scenario.prepareArrivalRate(totalDuration); err != nil {
+			return err
+		}
+	} else if scenario.Executor == SharedIterationsExecutor || scenario.Executor == PerUserIterationsExecutor {
+		if err := scenario.prepareIterations(totalDuration); err != nil {
+			return err
+		}
+	} else if totalDuration != 0 {
 		sumTimeBefore := 0.0
 		for i := 0; i < len(scenario.Steps); i++ {
 			if scenario.Steps[i].Action == DurationAction && sumTimeBefore+scenario.Steps[i].Period > totalDuration {
@@ -56,9 +104,10 @@ func (scenario *Scenario) PrepareScenario(totalDuration float64) {
 		}
 	}
 
-	scenario.stopUserChannel = make(chan struct{})
+	scenario.liveUsers = make(map[*User]bool)
 	scenario.stopScenarioChannel = make(chan struct{})
 	scenario.runningUserWait = &sync.WaitGroup{}
+	return nil
 }
 
 func (scenario *Scenario) StartUsersContinually(totalCount int, countByPeriod int, periodInMillis int, testName string, testRunId string) {
@@ -68,13 +117,15 @@ func (scenario *Scenario) StartUsersContinually(totalCount int, countByPeriod in
 	}
 }
 
+// StartUsers запускает count пользователей. Пользователи учитываются в runningUserWait до запуска горутин, чтобы
+// Run дождался каждого из них перед закрытием файла результатов.
 func (scenario *Scenario) StartUsers(count int, testName string, testRunId string) {
 	for i := 0; i < count; i++ {
+		scenario.runningUserWait.Add(1)
 		go func() {
+			defer scenario.runningUserWait.Done()
 			if !scenario.stopped.Load() {
-				scenario.runningUserWait.Add(1)
 				scenario.StartUser(testName, testRunId)
-				scenario.runningUserWait.Done()
 			}
 		}()
 	}
@@ -83,6 +134,27 @@ func (scenario *Scenario) StartUsers(count int, testName string, testRunId strin
 func (scenario *Scenario) Run(testName string, testRunId string) int64 {
 	startTime := time.Now().Unix()
 
+	switch scenario.Executor {
+	case ArrivalRateExecutor:
+		scenario.runArrivalRate(testName, testRunId)
+	case SharedIterationsExecutor, PerUserIterationsExecutor:
+		scenario.runIterations(testName, testRunId)
+	default:
+		scenario.runUsers(testName, testRunId)
+	}
+
+	scenario.stopAllUsers()
+
+	if !scenario.stopped.CompareAndSwap(false, true) {
+		<-scenario.stopScenarioChannel
+	}
+
+	scenario.runningUserWait.Wait()
+
+	return startTime
+}
+
+func (scenario *Scenario) runUsers(testName string, testRunId string) {
 	for _, step := range scenario.Steps {
 
 		if scenario.stopped.Load() {
@@ -103,64 +175,138 @@ func (scenario *Scenario) Run(testName string, testRunId string) int64 {
 			scenario.StopUsersContinually(step.TotalUsersCount, step.CountUsersByPeriod, int(step.Period*1000))
 		}
 	}
-
-	close(scenario.stopUserChannel)
-
-	if !scenario.stopped.CompareAndSwap(false, true) {
-		<-scenario.stopScenarioChannel
-	}
-
-	scenario.runningUserWait.Wait()
-
-	return startTime
 }
 
 func (scenario *Scenario) StopUsersContinually(totalCount int, countByPeriod int, periodInMillis int) {
-	stopUsersDone := &sync.WaitGroup{}
 	for i := 0; i < totalCount; i += countByPeriod {
 		if scenario.stopped.Load() {
 			break
 		}
+		scenario.stopUsers(countByPeriod)
+		time.Sleep(time.Duration(periodInMillis) * time.Millisecond)
+	}
+}
 
-		stopUsersDone.Add(countByPeriod)
-		go func() {
-			for j := 0; j < countByPeriod; j++ {
-				scenario.stopUserChannel <- struct{}{}
-				stopUsersDone.Done()
-			}
-		}()
+// registerUser добавляет пользователя в число работающих. Если сценарий уже завершается, пользователь сразу
+// получает сигнал остановки.
+func (scenario *Scenario) registerUser(user *User) {
+	user.stop = make(chan struct{})
+	scenario.usersMutex.Lock()
+	defer scenario.usersMutex.Unlock()
+	if scenario.usersStopped {
+		close(user.stop)
+	} else {
+		scenario.liveUsers[user] = true
+	}
+}
 
-		time.Sleep(time.Duration(periodInMillis) * time.Millisecond)
+func (scenario *Scenario) unregisterUser(user *User) {
+	scenario.usersMutex.Lock()
+	delete(scenario.liveUsers, user)
+	scenario.usersMutex.Unlock()
+}
+
+// stopUsers останавливает count работающих пользователей после их текущей итерации. Пользователи, которые
+// уже завершились сами, не учитываются, поэтому остановка не ждет их.
+func (scenario *Scenario) stopUsers(count int) {
+	scenario.usersMutex.Lock()
+	defer scenario.usersMutex.Unlock()
+	for user := range scenario.liveUsers {
+		if count <= 0 {
+			break
+		}
+		close(user.stop)
+		delete(scenario.liveUsers, user)
+		count--
+	}
+}
+
+func (scenario *Scenario) stopAllUsers() {
+	scenario.usersMutex.Lock()
+	defer scenario.usersMutex.Unlock()
+	scenario.usersStopped = true
+	for user := range scenario.liveUsers {
+		close(user.stop)
+		delete(scenario.liveUsers, user)
 	}
-	stopUsersDone.Wait()
 }
 
 func (scenario *Scenario) StartUser(testName string, testRunId string) {
-	usersCountMetric.WithLabelValues(testName, scenario.Name).Inc()
-	user := CreateUser(scenario.Script)
-	for {
+	scenario.addUsers(1, testName, testRunId)
+	defer scenario.addUsers(-1, testName, testRunId)
+	user, err := CreateUser(scenario.test.newHttpClient(), scenario.userScripts...)
+	defer scenario.test.releaseHttpClients(user)
+	if err != nil {
+		scenario.stopOnGenerationError(err, testRunId)
+		return
+	}
+	user.interrupt = scenario.stopScenarioChannel
+	scenario.registerUser(user)
+	defer scenario.unregisterUser(user)
+	if !scenario.startUserHook(user, testName, testRunId) {
+		return
+	}
+	defer scenario.stopUserHook(user, testName, testRunId)
+	if !scenario.acquireIteration(0) {
+		return
+	}
+	for userIterations := 1; ; userIterations++ {
 		timeBeforeIteration := time.Now().UnixMilli()
-		result := scenario.Script.ProcessHttp(testName, testRunId, user)
-		if result {
-			successScenarioCountMetric.WithLabelValues(testName, scenario.Name).Observe(float64(time.Now().UnixMilli()-timeBeforeIteration) / 1000.0)
-		} else {
-			failedScenarioCountMetric.WithLabelValues(testName, scenario.Name).Inc()
+		switch scenario.runIteration(user, testName, testRunId) {
+		case iterationStopTest:
+			scenario.test.Stop()
+			return
+		case iterationStopUser, iterationFailedStopUser:
+			return
+		}
+		if !scenario.acquireIteration(userIterations) {
+			return
 		}
 		currentPacing := ((user.userRand.Float64()*2-1)*scenario.PacingDelta + 1) * scenario.Pacing
 		timeToSleep := int64(currentPacing*1000) - time.Now().UnixMilli() + timeBeforeIteration
 		if timeToSleep < 1 {
 			timeToSleep = 1
 		}
-		select {
-		case <-scenario.stopUserChannel:
-			usersCountMetric.WithLabelValues(testName, scenario.Name).Dec()
+		if !user.sleep(time.Duration(timeToSleep) * time.Millisecond) {
 			return
-		case <-time.After(time.Duration(timeToSleep) * time.Millisecond):
-			continue
 		}
 	}
 }
 
+func (scenario *Scenario) runIteration(user *User, testName string, testRunId string) iterationResult {
+	timeBeforeIteration := time.Now()
+	result := scenario.chooseScript(user).ProcessHttp(testName, testRunId, scenario.Name, user)
+	duration := time.Since(timeBeforeIteration)
+	switch result {
+	case iterationSucceeded:
+		scenario.stats.record(duration, false)
+		scenario.latency.observeScenario(duration, runLabelValues(testName, testRunId, scenario.Name)...)
+		emit(TimingEvent, "scenario_success", durationMillis(duration),
+			"test_name", testName, "test_run_id", testRunId, "scenario_name", scenario.Name)
+	case iterationFailed, iterationFailedStopUser:
+		scenario.stats.record(duration, true)
+		failedScenarioCountMetric.WithLabelValues(runLabelValues(testName, testRunId, scenario.Name)...).Inc()
+		emit(CounterEvent, "scenario_failed", 1, "test_name", testName, "test_run_id", testRunId, "scenario_name", scenario.Name)
+	}
+	return result
+}
+
+// addUsers меняет число работающих пользователей сценария на delta
+func (scenario *Scenario) addUsers(delta int64, testName string, testRunId string) {
+	usersCountMetric.WithLabelValues(runLabelValues(testName, testRunId, scenario.Name)...).Add(float64(delta))
+	users := atomic.AddInt64(&scenario.runningUsers, delta)
+	emit(GaugeEvent, "users_running", float64(users), "test_name", testName, "test_run_id", testRunId,
+		"scenario_name", scenario.Name)
+}
+
+func (scenario *Scenario) stopOnGenerationError(err error, testRunId string) {
+	log.Error().Err(err).Str("testRunId", testRunId).Str("scenario", scenario.Name).
+		Msg("Не удалось сгенерировать переменные пользователя")
+	if errors.Is(err, variables.ErrStopTest) {
+		scenario.test.Stop()
+	}
+}
+
 func (scenario *Scenario) Stop() {
 	if scenario.stopped.CompareAndSwap(false, true) {
 		scenario.stopScenarioChannel <- struct{}{}
@@ -0,0 +1,157 @@
+package load
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func runWithTimeout(t *testing.T, test *Test, timeout time.Duration) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		test.Run()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		t.Fatalf("test %s did not finish in %v", test.Id, timeout)
+	}
+}
+
+func TestStopStepAfterUsersStoppedByErrorPolicy(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer target.Close()
+
+	script := &Script{Name: "failing", Steps: []*Step{
+		{Name: "request", Url: target.URL, Method: http.MethodGet, OnError: &ErrorPolicy{Action: StopUserAction}},
+	}}
+	scenario := &Scenario{Name: "stop-users", Script: script, Pacing: 0.01, Steps: []ScenarioStep{
+		{Action: StartAction, TotalUsersCount: 2, CountUsersByPeriod: 2},
+		{Action: DurationAction, Period: 0.2},
+		{Action: StopAction, TotalUsersCount: 2, CountUsersByPeriod: 2},
+	}}
+	test := &Test{Id: "stop-users-run", Name: "stop-users", Options: &TestOptions{}, Scenarios: []*Scenario{scenario}}
+	if err := test.PrepareTest(); err != nil {
+		t.Fatal(err)
+	}
+
+	runWithTimeout(t, test, 5*time.Second)
+
+	summary := test.Report().Scenarios[0].Iterations
+	if summary.Count != 2 || summary.Failed != 2 {
+		t.Errorf("expected 2 failed iterations, got %d of %d", summary.Failed, summary.Count)
+	}
+}
+
+func TestStopDuringStopStep(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+
+	script := &Script{Name: "ok", Steps: []*Step{{Name: "request", Url: target.URL, Method: http.MethodGet}}}
+	scenario := &Scenario{Name: "stop-more-users", Script: script, Pacing: 0.01, Steps: []ScenarioStep{
+		{Action: StartAction, TotalUsersCount: 1, CountUsersByPeriod: 1},
+		{Action: DurationAction, Period: 0.1},
+		{Action: StopAction, TotalUsersCount: 3, CountUsersByPeriod: 1, Period: 0.1},
+		{Action: DurationAction, Period: 60},
+	}}
+	test := &Test{Id: "stop-more-users-run", Name: "stop-more-users", Options: &TestOptions{}, Scenarios: []*Scenario{scenario}}
+	if err := test.PrepareTest(); err != nil {
+		t.Fatal(err)
+	}
+
+	time.AfterFunc(time.Second, test.Stop)
+	runWithTimeout(t, test, 5*time.Second)
+}
+
+func TestStopInterruptsThinkTimeAndBackoff(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer target.Close()
+
+	script := &Script{Name: "slow", Steps: []*Step{
+		{Name: "think", Url: target.URL, Method: http.MethodGet, ThinkTime: &Delay{Value: 60}},
+		{Name: "retry", Url: target.URL + "/fail", Method: http.MethodGet,
+			OnError: &ErrorPolicy{Action: RetryAction, Retries: 5, Backoff: &Delay{Value: 60}}},
+	}}
+	thinking := &Scenario{Name: "thinking", Script: script, Steps: []ScenarioStep{
+		{Action: StartAction, TotalUsersCount: 2, CountUsersByPeriod: 2},
+		{Action: DurationAction, Period: 60},
+	}}
+	retryScript := &Script{Name: "retry", Steps: []*Step{{Name: "retry", Url: target.URL + "/fail", Method: http.MethodGet,
+		OnError: &ErrorPolicy{Action: RetryAction, Retries: 5, Backoff: &Delay{Value: 60}}}}}
+	retrying := &Scenario{Name: "retrying", Script: retryScript,
+		Executor: ArrivalRateExecutor, MaxUsers: 2, Steps: []ScenarioStep{
+			{Action: RateAction, Rate: 10, Period: 0.1},
+			{Action: DurationAction, Period: 60},
+		}}
+	test := &Test{Id: "think-time-run", Name: "think-time", Options: &TestOptions{},
+		Scenarios: []*Scenario{thinking, retrying}}
+	if err := test.PrepareTest(); err != nil {
+		t.Fatal(err)
+	}
+
+	time.AfterFunc(500*time.Millisecond, test.Stop)
+	runWithTimeout(t, test, 5*time.Second)
+}
+
+func TestScenarioEndInterruptsThinkTime(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+
+	script := &Script{Name: "think", Steps: []*Step{
+		{Name: "think", Url: target.URL, Method: http.MethodGet, ThinkTime: &Delay{Value: 3}},
+	}}
+	closed := &Scenario{Name: "closed", Script: script, Steps: []ScenarioStep{
+		{Action: StartAction, TotalUsersCount: 1, CountUsersByPeriod: 1},
+		{Action: DurationAction, Period: 0.3},
+	}}
+	arrival := &Scenario{Name: "arrival", Script: script, Executor: ArrivalRateExecutor, MaxUsers: 2,
+		Steps: []ScenarioStep{{Action: RateAction, Rate: 10, Period: 0.3}}}
+	test := &Test{Id: "scenario-end-run", Name: "scenario-end", Options: &TestOptions{},
+		Scenarios: []*Scenario{closed, arrival}}
+	if err := test.PrepareTest(); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	runWithTimeout(t, test, 5*time.Second)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("test with 0.3s scenarios and 3s think time took %v", elapsed)
+	}
+}
+
+func TestStopStepInterruptsThinkTime(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+
+	script := &Script{Name: "think", Steps: []*Step{
+		{Name: "think", Url: target.URL, Method: http.MethodGet, ThinkTime: &Delay{Value: 60}},
+	}}
+	scenario := &Scenario{Name: "ramp-down", Script: script, Steps: []ScenarioStep{
+		{Action: StartAction, TotalUsersCount: 1, CountUsersByPeriod: 1},
+		{Action: DurationAction, Period: 0.2},
+		{Action: StopAction, TotalUsersCount: 1, CountUsersByPeriod: 1},
+		{Action: DurationAction, Period: 60},
+	}}
+	test := &Test{Id: "ramp-down-run", Name: "ramp-down", Options: &TestOptions{}, Scenarios: []*Scenario{scenario}}
+	if err := test.PrepareTest(); err != nil {
+		t.Fatal(err)
+	}
+
+	time.AfterFunc(time.Second, func() {
+		if users := atomic.LoadInt64(&scenario.runningUsers); users != 0 {
+			t.Errorf("%d users are still running after the stop step", users)
+		}
+		test.Stop()
+	})
+	runWithTimeout(t, test, 5*time.Second)
+}
@@ -2,10 +2,14 @@ package load
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
+	"errors"
+	"fmt"
 	"io"
 	"math/rand"
 	"net/http"
+	"net/http/httptrace"
 	"sort"
 	"strconv"
 	"strings"
@@ -19,10 +23,26 @@ import (
 
 const requestIdLength = 15
 
+type VariableSyntax string
+
+const (
+	RegexSyntax    VariableSyntax = "regex"
+	TemplateSyntax VariableSyntax = "template"
+)
+
 type Script struct {
-	Name      string
-	Steps     []*Step
-	Variables map[string]*variables.Variable
+	Name           string
+	Steps          []*Step
+	Variables      map[string]*variables.Variable
+	Syntax         VariableSyntax
+	Weight         float64
+	variables      map[string]*variables.Variable
+	variableOrder  []string
+	sharedValues   map[string]string
+	knownVariables map[string]bool
+	stats          *histogram
+	results        *resultWriter
+	latency        *latencyMetrics
 }
 
 type Step struct {
@@ -31,123 +51,568 @@ type Step struct {
 	Url        string
 	Method     string
 	Headers    map[string]string
-	httpClient *http.Client
 	Timeout    int64
+	Extractors []*Extractor
+	Checks     []*Check
+	ThinkTime  *Delay
+	OnError    *ErrorPolicy `mapstructure:"onError" json:"onError"`
+	Steps      []*Step
+	Branches   []*Branch
+	Repeat     *Repeat
+	When       *Condition
+	Tls        *TlsOptions
+	templates  *stepTemplates
+	transport  *http.Transport
+	ownPools   bool
+	stats      *histogram
 }
 
-func (script *Script) ProcessHttp(testName string, testRunId string, user *User) bool {
-	success := true
-	iter := script.prepareIteration(user, testRunId)
+type stepTemplates struct {
+	method  *variables.Template
+	url     *variables.Template
+	body    *variables.Template
+	headers map[string]*variables.Template
+}
 
-	for _, step := range script.Steps {
-		var req *http.Request
-		var err error
+func (script *Script) PrepareScript(testVariables map[string]*variables.Variable, testValues map[string]string,
+	externalVariables map[string]bool) error {
+	for name, variable := range script.Variables {
+		if err := variable.Prepare(name); err != nil {
+			return fmt.Errorf("script %s: %w", script.Name, err)
+		}
+	}
 
-		var resultMessage string
-		if step.Message == "" {
-			req, err = http.NewRequest(step.Method, step.Url, nil)
-		} else {
-			resultMessage = script.prepareStep(user, iter, step)
-			req, err = http.NewRequest(step.Method, step.Url, bytes.NewBufferString(resultMessage))
+	script.variables = make(map[string]*variables.Variable, len(testVariables)+len(script.Variables))
+	for name, variable := range testVariables {
+		script.variables[name] = variable
+	}
+	for name, variable := range script.Variables {
+		script.variables[name] = variable
+	}
+
+	knownVariables := variableNames(script.variables)
+	for name := range externalVariables {
+		knownVariables[name] = true
+	}
+	script.knownVariables = knownVariables
+	_ = forEachStep(script.Steps, func(step *Step) error {
+		for _, extractor := range step.Extractors {
+			knownVariables[extractor.Variable] = true
 		}
+		return nil
+	})
 
-		if err != nil {
-			beginLogInScript(true, err, iter, step.Name).Msgf("Не удалось создать объект запроса")
-			break
+	var err error
+	if script.variableOrder, err = orderVariables(script.variables, knownVariables); err != nil {
+		return fmt.Errorf("script %s: %w", script.Name, err)
+	}
+
+	err = forEachStep(script.Steps, func(step *Step) error {
+		if err := step.validateFlow(knownVariables); err != nil {
+			return fmt.Errorf("script %s, step %s: %w", script.Name, step.Name, err)
+		}
+		for _, extractor := range step.Extractors {
+			if err := extractor.validate(); err != nil {
+				return fmt.Errorf("script %s, step %s: extractor for variable %s: %w", script.Name, step.Name,
+					extractor.Variable, err)
+			}
+		}
+		for _, check := range step.Checks {
+			if err := check.validate(); err != nil {
+				return fmt.Errorf("script %s, step %s: %s check: %w", script.Name, step.Name, check.Type, err)
+			}
+		}
+		if step.ThinkTime != nil {
+			if err := step.ThinkTime.validate(); err != nil {
+				return fmt.Errorf("script %s, step %s: think time: %w", script.Name, step.Name, err)
+			}
+		}
+		if step.OnError != nil {
+			if err := step.OnError.validate(); err != nil {
+				return fmt.Errorf("script %s, step %s: on error: %w", script.Name, step.Name, err)
+			}
 		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
 
-		for key, value := range step.Headers {
-			req.Header.Set(key, value)
+	script.sharedValues = make(map[string]string, len(testValues))
+	for name, value := range testValues {
+		script.sharedValues[name] = value
+	}
+	err = generateVariables(script.Variables, script.variableOrder, variables.TestScope, initRand(), script.sharedValues)
+	if err != nil {
+		return fmt.Errorf("script %s: %w", script.Name, err)
+	}
+
+	if script.Syntax != TemplateSyntax {
+		return script.checkInsertions()
+	}
+
+	return forEachStep(script.Steps, func(step *Step) error {
+		if err := step.parseTemplates(knownVariables); err != nil {
+			return fmt.Errorf("script %s, step %s: %w", script.Name, step.Name, err)
 		}
+		return nil
+	})
+}
 
-		requestId := randomId(user.userRand, requestIdLength)
-		beginLogInScript(false, nil, iter, step.Name).
-			Str("body", resultMessage).Str("requestId", requestId).Msg("Отправка запроса")
+// checkInsertions один раз для каждого запроса сообщает о переменных, для которых в нем не найдена группа для замены,
+// и запрещает переменные, группы которых пересекаются в одной части запроса
+func (script *Script) checkInsertions() error {
+	return forEachStep(script.Steps, func(step *Step) error {
+		if len(step.Steps) > 0 || len(step.Branches) > 0 {
+			return nil
+		}
+		texts := []string{step.Method, step.Url, step.Message}
+		for _, value := range step.Headers {
+			texts = append(texts, value)
+		}
+		inserted := make(map[string]bool)
+		for _, text := range texts {
+			replaces := script.findInsertions(text)
+			for i := 1; i < len(replaces); i++ {
+				if replaces[i].start < replaces[i-1].end {
+					return fmt.Errorf("script %s, step %s: variables %s and %s insert into overlapping parts of %q",
+						script.Name, step.Name, replaces[i-1].varName, replaces[i].varName, text)
+				}
+			}
+			for _, replacement := range replaces {
+				inserted[replacement.varName] = true
+			}
+		}
+		var missing []string
+		for name, variable := range script.variables {
+			if variable.InsertingRegex != nil && !inserted[name] {
+				missing = append(missing, name)
+			}
+		}
+		if len(missing) > 0 {
+			sort.Strings(missing)
+			log.Warn().Str("script", script.Name).Str("step", step.Name).Strs("variables", missing).
+				Msg("Не найдена группа для замены в запросе")
+		}
+		return nil
+	})
+}
 
-		startTime := time.Now().UnixMilli()
-		resp, err := step.httpClient.Do(req)
+// findInsertions возвращает отсортированные по началу группы для замены всех переменных скрипта в тексте
+func (script *Script) findInsertions(str string) replaceSlice {
+	var replaces replaceSlice
+	for name, variable := range script.variables {
+		if variable.InsertingRegex == nil {
+			continue
+		}
+		indexes := variable.InsertingRegex.FindStringSubmatchIndex(str)
+		if len(indexes) >= 4 && indexes[2] >= 0 {
+			replaces = append(replaces, replaceInfo{start: indexes[2], end: indexes[3], varName: name})
+		}
+	}
+	replaces.sortByStartIndex()
+	return replaces
+}
 
-		if err != nil || resp.StatusCode >= 300 {
-			failedTransactionCountMetric.WithLabelValues(testName, script.Name, step.Name, "true").Inc()
-			success = false
-			if err != nil {
-				beginLogInScript(true, err, iter, step.Name).
-					Str("requestId", requestId).Msg("Ошибка отправки запроса")
-			} else {
-				body, err := getResponseBody(resp)
-				if err != nil {
-					logReadResponseError(err, iter, step.Name, resp.StatusCode, requestId)
-				} else {
-					logReadResponse(iter, step.Name, resp.StatusCode, requestId, body, true)
+func variableNames(vars map[string]*variables.Variable) map[string]bool {
+	names := make(map[string]bool)
+	for name, variable := range vars {
+		for _, variableName := range variable.Names(name) {
+			names[variableName] = true
+		}
+	}
+	return names
+}
+
+// orderVariables располагает переменные так, чтобы derived переменные генерировались после тех, от которых зависят
+func orderVariables(vars map[string]*variables.Variable, knownVariables map[string]bool) ([]string, error) {
+	producers := make(map[string]string)
+	names := make([]string, 0, len(vars))
+	for name, variable := range vars {
+		names = append(names, name)
+		for _, variableName := range variable.Names(name) {
+			producers[variableName] = name
+		}
+	}
+	sort.Strings(names)
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	states := make(map[string]int, len(names))
+	order := make([]string, 0, len(names))
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch states[name] {
+		case visiting:
+			return fmt.Errorf("variable %s depends on itself", name)
+		case visited:
+			return nil
+		}
+		states[name] = visiting
+		for _, dependency := range vars[name].Dependencies() {
+			if !knownVariables[dependency] {
+				return fmt.Errorf("variable %s depends on unknown variable %s", name, dependency)
+			}
+			if producer, ok := producers[dependency]; ok {
+				if err := visit(producer); err != nil {
+					return err
 				}
 			}
-			break
-		} else {
-			successTransactionCountMetric.WithLabelValues(testName, script.Name, step.Name).
-				Observe(float64(time.Now().UnixMilli()-startTime) / 1000.0)
-
-			body, err := getResponseBody(resp)
-			if err != nil {
-				logReadResponseError(err, iter, step.Name, resp.StatusCode, requestId)
-			} else {
-				logReadResponse(iter, step.Name, resp.StatusCode, requestId, body, false)
+		}
+		states[name] = visited
+		order = append(order, name)
+		return nil
+	}
+
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+func (step *Step) parseTemplates(knownVariables map[string]bool) error {
+	var err error
+	templates := &stepTemplates{headers: make(map[string]*variables.Template, len(step.Headers))}
+	if templates.method, err = parseStepTemplate(step.Method, knownVariables); err != nil {
+		return fmt.Errorf("method: %w", err)
+	}
+	if templates.url, err = parseStepTemplate(step.Url, knownVariables); err != nil {
+		return fmt.Errorf("url: %w", err)
+	}
+	if templates.body, err = parseStepTemplate(step.Message, knownVariables); err != nil {
+		return fmt.Errorf("body: %w", err)
+	}
+	for key, value := range step.Headers {
+		if templates.headers[key], err = parseStepTemplate(value, knownVariables); err != nil {
+			return fmt.Errorf("header %s: %w", key, err)
+		}
+	}
+	step.templates = templates
+	return nil
+}
+
+func parseStepTemplate(text string, knownVariables map[string]bool) (*variables.Template, error) {
+	template, err := variables.ParseTemplate(text)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range template.Variables() {
+		if !knownVariables[name] {
+			return nil, fmt.Errorf("unknown variable %s", name)
+		}
+	}
+	return template, nil
+}
+
+func (script *Script) ProcessHttp(testName string, testRunId string, scenarioName string, user *User) iterationResult {
+	iter, err := script.prepareIteration(user, testName, testRunId, scenarioName)
+	if err != nil {
+		return stopResult(err, iter, "")
+	}
+
+	start := time.Now()
+	result := script.runSteps(script.Steps, testName, user, iter)
+	if result == iterationSucceeded && iter.failed {
+		result = iterationFailed
+	}
+	if result == iterationSucceeded || result == iterationFailed || result == iterationFailedStopUser {
+		script.stats.record(time.Since(start), result != iterationSucceeded)
+	}
+	return result
+}
+
+func (script *Script) processRequest(testName string, user *User, iter *iteration, step *Step) iterationResult {
+	for attempt := 1; ; attempt++ {
+		outcome := script.sendRequest(user, iter, step)
+		if outcome.result == iterationSucceeded {
+			step.stats.record(outcome.duration, false)
+			script.latency.observeTransaction(outcome.duration,
+				runLabelValues(testName, iter.testRunId, script.Name, step.Name)...)
+			emit(TimingEvent, "transaction_success", durationMillis(outcome.duration), "test_name", testName,
+				"test_run_id", iter.testRunId, "script_name", script.Name, "step_name", step.Name)
+			if step.ThinkTime != nil && !user.sleep(step.ThinkTime.duration(user.userRand)) {
+				return iterationStopUser
+			}
+			return outcome.result
+		}
+		if outcome.result != iterationFailed {
+			return outcome.result
+		}
+
+		if step.OnError.retryAllowed(attempt) {
+			backoff := step.OnError.backoff(attempt, user.userRand)
+			beginLogInScript(false, nil, iter, step.Name).Int("attempt", attempt).
+				Dur("backoff", backoff).Msg("Повтор запроса")
+			if user.sleep(backoff) {
+				retryTransactionCountMetric.WithLabelValues(
+					runLabelValues(testName, iter.testRunId, script.Name, step.Name, outcome.reason)...).Inc()
+				emit(CounterEvent, "transaction_retry", 1, "test_name", testName, "test_run_id", iter.testRunId,
+					"script_name", script.Name, "step_name", step.Name, "reason", outcome.reason)
+				continue
 			}
 		}
+
+		step.stats.record(outcome.duration, true)
+		statusCode := statusCodeLabel(outcome.statusCode)
+		failedTransactionCountMetric.WithLabelValues(runLabelValues(testName, iter.testRunId, script.Name, step.Name,
+			statusCode, outcome.errorClass, outcome.reason)...).Inc()
+		script.latency.observeFailedTransaction(outcome.duration,
+			runLabelValues(testName, iter.testRunId, script.Name, step.Name, statusCode, outcome.errorClass)...)
+		emit(TimingEvent, "transaction_failed", durationMillis(outcome.duration), "test_name", testName,
+			"test_run_id", iter.testRunId, "script_name", script.Name, "step_name", step.Name,
+			"status_code", statusCode, "error_class", outcome.errorClass, "reason", outcome.reason)
+		return step.OnError.resultOnFailure(iter)
 	}
+}
 
-	return success
+// requestOutcome описывает результат одной попытки запроса шага
+type requestOutcome struct {
+	result     iterationResult
+	reason     string
+	errorClass string
+	statusCode int
+	duration   time.Duration
 }
 
-func (script *Script) prepareIteration(user *User, testRunId string) *iteration {
-	script.generateVariablesForStage(user, variables.IterationScope)
-	return &iteration{
-		testRunId:  testRunId,
-		scriptName: script.Name,
-		userId:     user.id,
-		id:         randomId(user.userRand, iterationIdLength),
+// sendRequest выполняет один запрос шага и возвращает его длительность, а если он не прошел проверки - причину и класс ошибки
+func (script *Script) sendRequest(user *User, iter *iteration, step *Step) *requestOutcome {
+	prepared, err := script.prepareStep(user, step)
+	if err != nil {
+		return &requestOutcome{result: stopResult(err, iter, step.Name)}
+	}
+
+	var requestBody io.Reader
+	if prepared.body != "" {
+		requestBody = bytes.NewBufferString(prepared.body)
+	}
+	trace := newRequestTrace()
+	ctx := httptrace.WithClientTrace(context.Background(), trace.clientTrace())
+	if step.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(step.Timeout)*time.Millisecond)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, prepared.method, prepared.url, requestBody)
+	if err != nil {
+		beginLogInScript(true, err, iter, step.Name).Msgf("Не удалось создать объект запроса")
+		return &requestOutcome{result: iterationFailed, reason: requestErrorReason, errorClass: otherErrorClass}
+	}
+
+	for key, value := range prepared.headers {
+		req.Header.Set(key, value)
+	}
+
+	requestId := randomId(user.userRand, requestIdLength)
+	beginLogInScript(false, nil, iter, step.Name).
+		Str("url", prepared.url).Str("body", prepared.body).Str("requestId", requestId).Msg("Отправка запроса")
+
+	startTime := time.Now()
+	resp, err := step.httpClient(user).Do(req)
+	duration := time.Since(startTime)
+	defer trace.observe(script.latency, iter, script.Name, step.Name)
+
+	record := &requestResult{
+		Timestamp:   startTime,
+		TestId:      iter.testRunId,
+		Scenario:    iter.scenarioName,
+		Script:      script.Name,
+		Step:        step.Name,
+		UserId:      iter.userId,
+		IterationId: iter.id,
+		RequestId:   requestId,
+		Latency:     durationMillis(duration),
+	}
+	defer script.results.add(record)
+
+	if err != nil {
+		beginLogInScript(true, err, iter, step.Name).
+			Str("requestId", requestId).Msg("Ошибка отправки запроса")
+		record.Error = err.Error()
+		return &requestOutcome{result: iterationFailed, reason: requestErrorReason,
+			errorClass: classifyError(err, 0), duration: duration}
+	}
+	record.Status = resp.StatusCode
+	record.SentBytes = requestSize(req, len(prepared.body))
+	sentBytesMetric.WithLabelValues(runLabelValues(iter.testName, iter.testRunId, script.Name, step.Name)...).Add(float64(record.SentBytes))
+	emit(CounterEvent, "transaction_sent_bytes", float64(record.SentBytes), "test_name", iter.testName,
+		"test_run_id", iter.testRunId, "script_name", script.Name, "step_name", step.Name)
+
+	readStart := time.Now()
+	body, err := getResponseBody(resp)
+	trace.setBodyRead(time.Since(readStart))
+	record.ReceivedBytes = responseSize(resp, len(body))
+	receivedBytesMetric.WithLabelValues(runLabelValues(iter.testName, iter.testRunId, script.Name, step.Name)...).Add(float64(record.ReceivedBytes))
+	emit(CounterEvent, "transaction_received_bytes", float64(record.ReceivedBytes), "test_name", iter.testName,
+		"test_run_id", iter.testRunId, "script_name", script.Name, "step_name", step.Name)
+	if err != nil {
+		logReadResponseError(err, iter, step.Name, resp.StatusCode, requestId)
+		record.Error = err.Error()
+		return &requestOutcome{result: iterationFailed, statusCode: resp.StatusCode, reason: bodyReadReason,
+			errorClass: classifyError(err, resp.StatusCode), duration: duration}
+	}
+	stepResponse := &response{resp: resp, body: body}
+	reason, checkErr := step.checkResponse(stepResponse, duration.Milliseconds())
+	logReadResponse(iter, step.Name, resp.StatusCode, requestId, body, checkErr != nil)
+
+	if checkErr == nil {
+		if checkErr = step.extractVariables(stepResponse, user.scriptVariables); checkErr != nil {
+			reason = extractorReason
+		}
+	}
+
+	outcome := &requestOutcome{result: iterationSucceeded, statusCode: resp.StatusCode, duration: duration}
+	if checkErr != nil {
+		beginLogInScript(true, checkErr, iter, step.Name).Str("requestId", requestId).
+			Str("reason", reason).Msg("Проверка ответа не пройдена")
+		record.Error = checkErr.Error()
+		outcome.result = iterationFailed
+		outcome.reason = reason
+		outcome.errorClass = classifyError(nil, resp.StatusCode)
 	}
+	return outcome
 }
 
-func (script *Script) prepareStep(user *User, iter *iteration, step *Step) string {
-	script.generateVariablesForStage(user, variables.StepScope)
+// httpClient возвращает клиента пользователя, а для шага с собственными настройками TLS - его копию с транспортом шага.
+// Если у пользователей свои пулы соединений, транспорт шага тоже копируется для каждого пользователя.
+func (step *Step) httpClient(user *User) *http.Client {
+	if step.transport == nil {
+		return user.httpClient
+	}
+	client, exists := user.stepClients[step]
+	if !exists {
+		stepClient := *user.httpClient
+		stepClient.Transport = step.transport
+		if step.ownPools {
+			stepClient.Transport = step.transport.Clone()
+		}
+		client = &stepClient
+		user.stepClients[step] = client
+	}
+	return client
+}
+
+func (script *Script) prepareIteration(user *User, testName string, testRunId string, scenarioName string) (*iteration, error) {
+	iter := &iteration{
+		testName:     testName,
+		testRunId:    testRunId,
+		scenarioName: scenarioName,
+		scriptName:   script.Name,
+		userId:       user.id,
+		id:           randomId(user.userRand, iterationIdLength),
+	}
+	return iter, script.generateVariablesForStage(user, variables.IterationScope)
+}
 
+func (script *Script) prepareStep(user *User, step *Step) (*preparedRequest, error) {
+	if err := script.generateVariablesForStage(user, variables.StepScope); err != nil {
+		return nil, err
+	}
+
+	if step.templates != nil {
+		prepared := &preparedRequest{
+			method:  step.templates.method.Execute(user.scriptVariables),
+			url:     step.templates.url.Execute(user.scriptVariables),
+			body:    step.templates.body.Execute(user.scriptVariables),
+			headers: make(map[string]string, len(step.templates.headers)),
+		}
+		for key, template := range step.templates.headers {
+			prepared.headers[key] = template.Execute(user.scriptVariables)
+		}
+		return prepared, nil
+	}
+
+	prepared := &preparedRequest{
+		method:  script.insertVariables(user, step.Method),
+		url:     script.insertVariables(user, step.Url),
+		body:    script.insertVariables(user, step.Message),
+		headers: make(map[string]string, len(step.Headers)),
+	}
+	for key, value := range step.Headers {
+		prepared.headers[key] = script.insertVariables(user, value)
+	}
+	return prepared, nil
+}
+
+func (script *Script) insertVariables(user *User, str string) string {
 	var replaces replaceSlice
-	for name := range user.scriptVariables {
-		indexes := script.Variables[name].InsertingRegex.FindStringSubmatchIndex(step.Message)
-		if len(indexes) < 4 {
-			beginLogInScript(true, nil, iter, step.Name).
-				Str("variable", name).Msgf("Не найдена группа для замены в сообщении")
-		} else {
+	for name, variable := range script.variables {
+		if _, ok := user.scriptVariables[name]; !ok || variable.InsertingRegex == nil {
+			continue
+		}
+		indexes := variable.InsertingRegex.FindStringSubmatchIndex(str)
+		if len(indexes) >= 4 && indexes[2] >= 0 {
 			replaces = append(replaces, replaceInfo{start: indexes[2], end: indexes[3], varName: name})
 		}
 	}
 
 	if replaces != nil {
 		replaces.sortByStartIndex()
-		return replaceByIndexes(step.Message, replaces, user.scriptVariables)
+		return replaceByIndexes(str, replaces, user.scriptVariables)
 	} else {
-		return step.Message
+		return str
 	}
 }
 
-func (script *Script) generateVariablesForStage(user *User, stage variables.Scope) {
-	for name, variable := range script.Variables {
-		if variable.Scope == stage {
-			user.scriptVariables[name] = variable.Generate(user.userRand)
+func (script *Script) generateVariablesForStage(user *User, stage variables.Scope) error {
+	return generateVariables(script.variables, script.variableOrder, stage, user.userRand, user.scriptVariables)
+}
+
+func generateVariables(vars map[string]*variables.Variable, order []string, stage variables.Scope,
+	userRand *rand.Rand, values map[string]string) error {
+	for _, name := range order {
+		variable, ok := vars[name]
+		if ok && variable.Scope == stage && variable.Generated() {
+			if err := variable.Generate(name, userRand, values); err != nil {
+				return err
+			}
 		}
 	}
+	return nil
+}
+
+func stopResult(err error, iter *iteration, stepName string) iterationResult {
+	beginLogInScript(true, err, iter, stepName).Msg("Не удалось сгенерировать переменные")
+	if errors.Is(err, variables.ErrStopTest) {
+		return iterationStopTest
+	}
+	return iterationStopUser
 }
 
 func getResponseBody(resp *http.Response) (string, error) {
+	defer resp.Body.Close()
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return "", err
 	}
-
-	resp.Body.Close()
 	return string(body), nil
 }
 
+// requestSize приблизительно оценивает размер запроса: стартовая строка, заголовки и тело
+func requestSize(req *http.Request, bodyLength int) int {
+	size := len(req.Method) + len(req.URL.RequestURI()) + len(req.Proto) + 4 + bodyLength
+	for key, values := range req.Header {
+		for _, value := range values {
+			size += len(key) + len(value) + 4
+		}
+	}
+	return size
+}
+
+// responseSize приблизительно оценивает размер ответа: строка статуса, заголовки и тело после распаковки
+func responseSize(resp *http.Response, bodyLength int) int {
+	size := len(resp.Proto) + len(resp.Status) + 3 + bodyLength
+	for key, values := range resp.Header {
+		for _, value := range values {
+			size += len(key) + len(value) + 4
+		}
+	}
+	return size
+}
+
 func randomId(userRand *rand.Rand, length int) string {
 	b := make([]byte, length)
 	userRand.Read(b)
@@ -181,6 +646,13 @@ func beginLogInScript(isError bool, err error, iter *iteration, stepName string)
 		Str("userId", iter.userId).Str("iterationId", iter.id).Str("step", stepName)
 }
 
+type preparedRequest struct {
+	method  string
+	url     string
+	body    string
+	headers map[string]string
+}
+
 type replaceInfo struct {
 	start   int
 	end     int
@@ -199,6 +671,9 @@ func replaceByIndexes(str string, replaces replaceSlice, scriptVariables map[str
 	var result strings.Builder
 	var lastEnd int
 	for _, replacement := range replaces {
+		if replacement.start < lastEnd {
+			continue
+		}
 		result.WriteString(str[lastEnd:replacement.start])
 		result.WriteString(scriptVariables[replacement.varName])
 		lastEnd = replacement.end
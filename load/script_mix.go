@@ -0,0 +1,48 @@
+package load
+
+import "fmt"
+
+// prepareScripts собирает скрипты сценария: Script и все Scripts. Если скриптов несколько,
+// каждая итерация пользователя выбирает один из них с вероятностью, пропорциональной Weight.
+func (scenario *Scenario) prepareScripts() error {
+	scenario.scripts = scenario.Scripts
+	if scenario.Script != nil {
+		scenario.scripts = append([]*Script{scenario.Script}, scenario.Scripts...)
+	}
+	if len(scenario.scripts) == 0 {
+		return fmt.Errorf("scenario %s has no scripts", scenario.Name)
+	}
+
+	scenario.scriptWeightsSum = 0
+	for _, script := range scenario.scripts {
+		if script.Weight < 0 {
+			return fmt.Errorf("scenario %s: negative weight of script %s", scenario.Name, script.Name)
+		}
+		scenario.scriptWeightsSum += script.Weight
+	}
+	if len(scenario.scripts) > 1 && scenario.scriptWeightsSum == 0 {
+		return fmt.Errorf("scenario %s: sum of script weights is zero", scenario.Name)
+	}
+
+	scenario.userScripts = scenario.scripts
+	for _, hook := range []*Script{scenario.OnStart, scenario.OnStop} {
+		if hook != nil {
+			scenario.userScripts = append(scenario.userScripts[:len(scenario.userScripts):len(scenario.userScripts)], hook)
+		}
+	}
+	return nil
+}
+
+func (scenario *Scenario) chooseScript(user *User) *Script {
+	if len(scenario.scripts) == 1 {
+		return scenario.scripts[0]
+	}
+	point := user.userRand.Float64() * scenario.scriptWeightsSum
+	for _, script := range scenario.scripts {
+		if point < script.Weight {
+			return script
+		}
+		point -= script.Weight
+	}
+	return scenario.scripts[len(scenario.scripts)-1]
+}
@@ -0,0 +1,110 @@
+package load
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ledokol-inc/ledokol/load/variables"
+)
+
+func insertingScript(url string, pageRegex string) *Script {
+	return &Script{Name: "insert", Variables: map[string]*variables.Variable{
+		"id":   {Type: variables.ListType, Values: []string{"42"}, InsertingRegex: regexp.MustCompile(`/users/(\d+)`)},
+		"page": {Type: variables.ListType, Values: []string{"7"}, InsertingRegex: regexp.MustCompile(pageRegex)},
+	}, Steps: []*Step{{Name: "get", Url: url, Method: http.MethodGet}}}
+}
+
+func TestPrepareScriptRejectsOverlappingInsertions(t *testing.T) {
+	script := insertingScript("http://host/users/1/orders", `s/(\d+)`)
+	err := script.PrepareScript(nil, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "overlapping") {
+		t.Errorf("expected overlapping insertions error, got %v", err)
+	}
+
+	script = insertingScript("http://host/users/1/orders/2/", `orders/(\d+)`)
+	if err := script.PrepareScript(nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	user := &User{scriptVariables: map[string]string{"id": "42", "page": "7"}}
+	if url := script.insertVariables(user, script.Steps[0].Url); url != "http://host/users/42/orders/7/" {
+		t.Errorf("unexpected url %s", url)
+	}
+}
+
+func TestOrderVariables(t *testing.T) {
+	derived := func(expression string) *variables.Variable {
+		variable := &variables.Variable{Type: variables.DerivedType, Expression: expression}
+		if err := variable.Prepare("derived"); err != nil {
+			t.Fatal(err)
+		}
+		return variable
+	}
+	cases := []struct {
+		vars     map[string]*variables.Variable
+		expected string
+		err      string
+	}{
+		{map[string]*variables.Variable{
+			"email": derived("${login}@${domain}"),
+			"login": derived("user-${id}"),
+			"id":    {Type: variables.CounterType},
+		}, "id,login,email", ""},
+		{map[string]*variables.Variable{
+			"url": derived("/orders/${order}"),
+		}, "url", ""},
+		{map[string]*variables.Variable{
+			"url": derived("/orders/${missing}"),
+		}, "", "variable url depends on unknown variable missing"},
+		{map[string]*variables.Variable{
+			"first":  derived("${second}"),
+			"second": derived("${third}"),
+			"third":  derived("${first}"),
+		}, "", "variable first depends on itself"},
+		{map[string]*variables.Variable{
+			"self": derived("${self}"),
+		}, "", "variable self depends on itself"},
+	}
+	for i, c := range cases {
+		known := variableNames(c.vars)
+		known["domain"] = true
+		known["order"] = true
+		order, err := orderVariables(c.vars, known)
+		if c.err != "" {
+			if err == nil || err.Error() != c.err {
+				t.Errorf("case %d: got error %v, expected %q", i, err, c.err)
+			}
+		} else if err != nil || strings.Join(order, ",") != c.expected {
+			t.Errorf("case %d: got %v, %v, expected %s", i, order, err, c.expected)
+		}
+	}
+}
+
+func TestBodyReadTimeoutFailsTransaction(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		time.Sleep(500 * time.Millisecond)
+	}))
+	defer target.Close()
+
+	script := &Script{Name: "slow body", Steps: []*Step{{Name: "get", Url: target.URL, Method: http.MethodGet, Timeout: 100}}}
+	test := &Test{Id: "slow-body-run", Name: "slow-body", Setup: script, Options: &TestOptions{}}
+	if err := test.PrepareTest(); err != nil {
+		t.Fatal(err)
+	}
+	user, err := CreateUser(test.newHttpClient(), script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer test.releaseHttpClients(user)
+
+	outcome := script.sendRequest(user, &iteration{testName: test.Name, testRunId: test.Id}, script.Steps[0])
+	if outcome.result != iterationFailed || outcome.reason != bodyReadReason || outcome.errorClass != timeoutErrorClass ||
+		outcome.statusCode != http.StatusOK {
+		t.Errorf("unexpected outcome %+v", outcome)
+	}
+}
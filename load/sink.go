@@ -0,0 +1,234 @@
+package load
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+type EventType int
+
+const (
+	CounterEvent EventType = iota
+	GaugeEvent
+	TimingEvent
+)
+
+// aggregateShards - число частей, на которые aggregatingSink делит серии, чтобы пользователи реже ждали друг друга
+const aggregateShards = 16
+
+type Tag struct {
+	Key   string
+	Value string
+}
+
+// Event - одно измерение, которое параллельно с метриками Prometheus передается во все приемники.
+// Для TimingEvent Value - длительность в миллисекундах, для GaugeEvent - текущее значение.
+type Event struct {
+	Name  string
+	Type  EventType
+	Value float64
+	Tags  []Tag
+	Time  time.Time
+	key   string
+}
+
+// Sink принимает события теста. Send вызывается из горутин пользователей и не должен блокироваться.
+type Sink interface {
+	Send(event *Event)
+	Flush()
+	Close() error
+}
+
+var sinks []Sink
+
+// SetSinks задает приемники событий, вызывается до запуска тестов
+func SetSinks(newSinks []Sink) {
+	sinks = newSinks
+}
+
+// FlushSinks отправляет накопленные приемниками события, вызывается после завершения теста
+func FlushSinks() {
+	for _, sink := range sinks {
+		sink.Flush()
+	}
+}
+
+func CloseSinks() {
+	for _, sink := range sinks {
+		if err := sink.Close(); err != nil {
+			log.Error().Err(err).Msg("Не удалось закрыть приемник метрик")
+		}
+	}
+}
+
+// emit передает событие во все приемники, теги задаются парами ключ, значение. Пустые значения тегов
+// и отключенные метки запуска пропускаются.
+func emit(eventType EventType, name string, value float64, tags ...string) {
+	if len(sinks) == 0 {
+		return
+	}
+	event := &Event{Name: name, Type: eventType, Value: value, Time: time.Now(), Tags: make([]Tag, 0, len(tags)/2+1)}
+	if metricLabels.generatorId != "" {
+		event.Tags = append(event.Tags, Tag{Key: generatorIdLabel, Value: metricLabels.generatorId})
+	}
+	for i := 0; i+1 < len(tags); i += 2 {
+		if tags[i+1] != "" && (tags[i] != testRunIdLabel || metricLabels.testRunId) {
+			event.Tags = append(event.Tags, Tag{Key: tags[i], Value: tags[i+1]})
+		}
+	}
+	sortTags(event.Tags)
+	event.key = seriesKey(event)
+	for _, sink := range sinks {
+		sink.Send(event)
+	}
+}
+
+// aggregate - значения одной серии за интервал: сумма для счетчиков, последнее значение для gauge,
+// количество, сумма, минимум и максимум для длительностей
+type aggregate struct {
+	name      string
+	eventType EventType
+	tags      []Tag
+	count     uint64
+	sum       float64
+	min       float64
+	max       float64
+	last      float64
+}
+
+func (aggregate *aggregate) mean() float64 {
+	return aggregate.sum / float64(aggregate.count)
+}
+
+// aggregatingSink копит события и раз в interval передает агрегаты серий в write. Нужен приемникам, которые
+// не агрегируют значения сами (InfluxDB, Graphite).
+type aggregatingSink struct {
+	name     string
+	shards   [aggregateShards]aggregateShard
+	write    func(aggregates []*aggregate, now time.Time) error
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+type aggregateShard struct {
+	mutex  sync.Mutex
+	series map[string]*aggregate
+}
+
+func newAggregatingSink(name string, interval time.Duration, write func([]*aggregate, time.Time) error) *aggregatingSink {
+	sink := &aggregatingSink{
+		name:  name,
+		write: write,
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	for i := range sink.shards {
+		sink.shards[i].series = make(map[string]*aggregate)
+	}
+	go func() {
+		defer close(sink.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				sink.Flush()
+			case <-sink.stop:
+				return
+			}
+		}
+	}()
+	return sink
+}
+
+func (sink *aggregatingSink) Send(event *Event) {
+	key := event.key
+	if key == "" {
+		key = seriesKey(event)
+	}
+	shard := &sink.shards[shardIndex(key)]
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	series, exists := shard.series[key]
+	if !exists {
+		series = &aggregate{name: event.Name, eventType: event.Type, tags: event.Tags, min: event.Value, max: event.Value}
+		shard.series[key] = series
+	}
+	series.count++
+	series.sum += event.Value
+	series.last = event.Value
+	if event.Value < series.min {
+		series.min = event.Value
+	}
+	if event.Value > series.max {
+		series.max = event.Value
+	}
+}
+
+func (sink *aggregatingSink) Flush() {
+	var aggregates []*aggregate
+	for i := range sink.shards {
+		shard := &sink.shards[i]
+		shard.mutex.Lock()
+		for _, series := range shard.series {
+			aggregates = append(aggregates, series)
+		}
+		shard.series = make(map[string]*aggregate, len(shard.series))
+		shard.mutex.Unlock()
+	}
+
+	if len(aggregates) == 0 {
+		return
+	}
+	if err := sink.write(aggregates, time.Now()); err != nil {
+		log.Error().Err(err).Str("sink", sink.name).Int("series", len(aggregates)).
+			Msg("Не удалось отправить метрики в приемник")
+	}
+}
+
+func (sink *aggregatingSink) Close() error {
+	sink.stopOnce.Do(func() { close(sink.stop) })
+	<-sink.done
+	sink.Flush()
+	return nil
+}
+
+func seriesKey(event *Event) string {
+	var key strings.Builder
+	size := len(event.Name)
+	for _, tag := range event.Tags {
+		size += len(tag.Key) + len(tag.Value) + 2
+	}
+	key.Grow(size)
+	key.WriteString(event.Name)
+	for _, tag := range event.Tags {
+		key.WriteByte(0)
+		key.WriteString(tag.Key)
+		key.WriteByte(0)
+		key.WriteString(tag.Value)
+	}
+	return key.String()
+}
+
+// sortTags сортирует теги по ключу вставками: тегов мало, и в отличие от sort.Slice сортировка не выделяет память
+func sortTags(tags []Tag) {
+	for i := 1; i < len(tags); i++ {
+		for j := i; j > 0 && tags[j].Key < tags[j-1].Key; j-- {
+			tags[j], tags[j-1] = tags[j-1], tags[j]
+		}
+	}
+}
+
+// shardIndex выбирает часть aggregatingSink по хешу FNV-1a ключа серии
+func shardIndex(key string) int {
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= 16777619
+	}
+	return int(hash % aggregateShards)
+}
@@ -0,0 +1,59 @@
+package load
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const graphiteDialTimeout = 5 * time.Second
+
+var graphiteTagEscaper = strings.NewReplacer(";", "_", "~", "_", " ", "_", "=", "_")
+
+// NewGraphiteSink отправляет агрегаты по TCP в plaintext протоколе Graphite с тегами (name;tag=value value time).
+// Длительности в миллисекундах отправляются сериями <name>.count, .sum, .min, .max и .mean.
+func NewGraphiteSink(address string, prefix string, interval time.Duration) Sink {
+	return newAggregatingSink("graphite", interval, func(aggregates []*aggregate, now time.Time) error {
+		conn, err := net.DialTimeout("tcp", address, graphiteDialTimeout)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		_ = conn.SetWriteDeadline(now.Add(interval))
+		_, err = conn.Write(graphiteLines(aggregates, prefix, now))
+		return err
+	})
+}
+
+func graphiteLines(aggregates []*aggregate, prefix string, now time.Time) []byte {
+	var lines bytes.Buffer
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	writeLine := func(series *aggregate, suffix string, value float64) {
+		if prefix != "" {
+			lines.WriteString(prefix + ".")
+		}
+		lines.WriteString(graphiteTagEscaper.Replace(series.name) + suffix)
+		for _, tag := range series.tags {
+			lines.WriteString(";" + graphiteTagEscaper.Replace(tag.Key) + "=" + graphiteTagEscaper.Replace(tag.Value))
+		}
+		lines.WriteString(" " + formatFloat(value) + " " + timestamp + "\n")
+	}
+
+	for _, series := range aggregates {
+		switch series.eventType {
+		case TimingEvent:
+			writeLine(series, ".count", float64(series.count))
+			writeLine(series, ".sum", series.sum)
+			writeLine(series, ".min", series.min)
+			writeLine(series, ".max", series.max)
+			writeLine(series, ".mean", series.mean())
+		case GaugeEvent:
+			writeLine(series, "", series.last)
+		default:
+			writeLine(series, "", series.sum)
+		}
+	}
+	return lines.Bytes()
+}
@@ -0,0 +1,112 @@
+package load
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxUdpPayload = 1400
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	influxTagEscaper         = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+)
+
+// NewInfluxHttpSink отправляет агрегаты в InfluxDB в line protocol запросом POST на url, например
+// http://localhost:8086/write?db=ledokol или http://localhost:8086/api/v2/write?org=org&bucket=ledokol.
+// Непустой token передается в заголовке Authorization.
+func NewInfluxHttpSink(url string, token string, interval time.Duration) Sink {
+	client := &http.Client{Timeout: interval}
+	return newAggregatingSink("influx", interval, func(aggregates []*aggregate, now time.Time) error {
+		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(influxLines(aggregates, now)))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+		if token != "" {
+			req.Header.Set("Authorization", "Token "+token)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+			return fmt.Errorf("influx responded with status %d: %s", resp.StatusCode, body)
+		}
+		return nil
+	})
+}
+
+// NewInfluxUdpSink отправляет агрегаты в UDP listener InfluxDB, разбивая строки по датаграммам
+func NewInfluxUdpSink(address string, interval time.Duration) (Sink, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	return newAggregatingSink("influx", interval, func(aggregates []*aggregate, now time.Time) error {
+		return writeDatagrams(conn, influxLines(aggregates, now))
+	}), nil
+}
+
+// influxLines формирует строки line protocol: для длительностей поля count, sum, min, max и mean в миллисекундах,
+// для счетчиков и gauge - поле value
+func influxLines(aggregates []*aggregate, now time.Time) []byte {
+	var lines bytes.Buffer
+	timestamp := strconv.FormatInt(now.UnixNano(), 10)
+	for _, series := range aggregates {
+		lines.WriteString(influxMeasurementEscaper.Replace(series.name))
+		for _, tag := range series.tags {
+			lines.WriteByte(',')
+			lines.WriteString(influxTagEscaper.Replace(tag.Key))
+			lines.WriteByte('=')
+			lines.WriteString(influxTagEscaper.Replace(tag.Value))
+		}
+		lines.WriteByte(' ')
+		switch series.eventType {
+		case TimingEvent:
+			fmt.Fprintf(&lines, "count=%di,sum=%s,min=%s,max=%s,mean=%s", series.count, formatFloat(series.sum),
+				formatFloat(series.min), formatFloat(series.max), formatFloat(series.mean()))
+		case GaugeEvent:
+			lines.WriteString("value=" + formatFloat(series.last))
+		default:
+			lines.WriteString("value=" + formatFloat(series.sum))
+		}
+		lines.WriteByte(' ')
+		lines.WriteString(timestamp)
+		lines.WriteByte('\n')
+	}
+	return lines.Bytes()
+}
+
+// writeDatagrams отправляет строки датаграммами не больше maxUdpPayload, не разрывая строки
+func writeDatagrams(conn net.Conn, lines []byte) error {
+	for len(lines) > 0 {
+		end := len(lines)
+		if end > maxUdpPayload {
+			end = bytes.LastIndexByte(lines[:maxUdpPayload], '\n') + 1
+			if end == 0 {
+				end = bytes.IndexByte(lines, '\n') + 1
+			}
+			if end == 0 {
+				end = len(lines)
+			}
+		}
+		if _, err := conn.Write(lines[:end]); err != nil {
+			return err
+		}
+		lines = lines[end:]
+	}
+	return nil
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
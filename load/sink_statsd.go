@@ -0,0 +1,149 @@
+package load
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	statsdBufferSize    = 10000
+	statsdFlushInterval = 100 * time.Millisecond
+)
+
+var statsdEscaper = strings.NewReplacer(":", "_", "|", "_", "@", "_", "#", "_", ",", "_", " ", "_", "\n", "_")
+
+// statsdSink отправляет каждое событие по UDP, агрегирует значения сам StatsD. Строки собираются в датаграммы
+// в отдельной горутине, при переполнении буфера события отбрасываются.
+type statsdSink struct {
+	conn      net.Conn
+	prefix    string
+	tags      bool
+	lines     chan string
+	flush     chan chan struct{}
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewStatsdSink создает приемник StatsD. Если tags включены, теги передаются в формате DogStatsD (|#key:value),
+// иначе их значения добавляются к имени метрики через точку.
+func NewStatsdSink(address string, prefix string, tags bool) (Sink, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	sink := &statsdSink{
+		conn:   conn,
+		prefix: prefix,
+		tags:   tags,
+		lines:  make(chan string, statsdBufferSize),
+		flush:  make(chan chan struct{}),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go sink.run()
+	return sink, nil
+}
+
+func (sink *statsdSink) Send(event *Event) {
+	select {
+	case sink.lines <- sink.line(event):
+	default:
+	}
+}
+
+func (sink *statsdSink) line(event *Event) string {
+	var line strings.Builder
+	if sink.prefix != "" {
+		line.WriteString(sink.prefix + ".")
+	}
+	line.WriteString(statsdEscaper.Replace(event.Name))
+	if !sink.tags {
+		for _, tag := range event.Tags {
+			line.WriteString("." + strings.ReplaceAll(statsdEscaper.Replace(tag.Value), ".", "_"))
+		}
+	}
+	line.WriteString(":" + formatFloat(event.Value))
+	switch event.Type {
+	case TimingEvent:
+		line.WriteString("|ms")
+	case GaugeEvent:
+		line.WriteString("|g")
+	default:
+		line.WriteString("|c")
+	}
+	if sink.tags && len(event.Tags) > 0 {
+		line.WriteString("|#")
+		for i, tag := range event.Tags {
+			if i > 0 {
+				line.WriteByte(',')
+			}
+			line.WriteString(statsdEscaper.Replace(tag.Key) + ":" + statsdEscaper.Replace(tag.Value))
+		}
+	}
+	line.WriteByte('\n')
+	return line.String()
+}
+
+func (sink *statsdSink) run() {
+	defer close(sink.done)
+	ticker := time.NewTicker(statsdFlushInterval)
+	defer ticker.Stop()
+	var packet bytes.Buffer
+	send := func() {
+		if packet.Len() == 0 {
+			return
+		}
+		if _, err := sink.conn.Write(packet.Bytes()); err != nil {
+			log.Debug().Err(err).Msg("Не удалось отправить метрики в StatsD")
+		}
+		packet.Reset()
+	}
+	add := func(line string) {
+		if packet.Len()+len(line) > maxUdpPayload {
+			send()
+		}
+		packet.WriteString(line)
+	}
+	sendPending := func() {
+		for pending := len(sink.lines); pending > 0; pending-- {
+			add(<-sink.lines)
+		}
+		send()
+	}
+
+	for {
+		select {
+		case line := <-sink.lines:
+			add(line)
+		case flushed := <-sink.flush:
+			sendPending()
+			close(flushed)
+		case <-ticker.C:
+			send()
+		case <-sink.stop:
+			sendPending()
+			return
+		}
+	}
+}
+
+func (sink *statsdSink) Flush() {
+	flushed := make(chan struct{})
+	select {
+	case sink.flush <- flushed:
+		<-flushed
+	case <-sink.done:
+	}
+}
+
+func (sink *statsdSink) Close() error {
+	sink.closeOnce.Do(func() { close(sink.stop) })
+	<-sink.done
+	return sink.conn.Close()
+}
@@ -0,0 +1,260 @@
+package load
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+var sinkTestTime = time.Unix(1700000000, 0)
+
+func testAggregates() []*aggregate {
+	return []*aggregate{
+		{name: "transaction_success", eventType: TimingEvent, tags: []Tag{{"step_name", "log in"}, {"test_name", "a,b=c"}},
+			count: 4, sum: 10, min: 1, max: 4.5},
+		{name: "users_running", eventType: GaugeEvent, tags: []Tag{{"scenario_name", "main"}}, count: 2, sum: 7, last: 3},
+		{name: "transaction_retry", eventType: CounterEvent, count: 3, sum: 3},
+	}
+}
+
+func TestInfluxLines(t *testing.T) {
+	expected := `transaction_success,step_name=log\ in,test_name=a\,b\=c count=4i,sum=10,min=1,max=4.5,mean=2.5 1700000000000000000
+users_running,scenario_name=main value=3 1700000000000000000
+transaction_retry value=3 1700000000000000000
+`
+	if lines := string(influxLines(testAggregates(), sinkTestTime)); lines != expected {
+		t.Errorf("got\n%s\nexpected\n%s", lines, expected)
+	}
+}
+
+func TestGraphiteLines(t *testing.T) {
+	expected := `ledokol.transaction_success.count;step_name=log_in;test_name=a,b_c 4 1700000000
+ledokol.transaction_success.sum;step_name=log_in;test_name=a,b_c 10 1700000000
+ledokol.transaction_success.min;step_name=log_in;test_name=a,b_c 1 1700000000
+ledokol.transaction_success.max;step_name=log_in;test_name=a,b_c 4.5 1700000000
+ledokol.transaction_success.mean;step_name=log_in;test_name=a,b_c 2.5 1700000000
+ledokol.users_running;scenario_name=main 3 1700000000
+ledokol.transaction_retry 3 1700000000
+`
+	if lines := string(graphiteLines(testAggregates(), "ledokol", sinkTestTime)); lines != expected {
+		t.Errorf("got\n%s\nexpected\n%s", lines, expected)
+	}
+}
+
+func listenUdp(t *testing.T) net.PacketConn {
+	t.Helper()
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	return listener
+}
+
+func readDatagrams(t *testing.T, listener net.PacketConn, count int) []string {
+	t.Helper()
+	var datagrams []string
+	buffer := make([]byte, 65536)
+	_ = listener.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for len(datagrams) < count {
+		n, _, err := listener.ReadFrom(buffer)
+		if err != nil {
+			t.Fatalf("received %d of %d datagrams: %v", len(datagrams), count, err)
+		}
+		datagrams = append(datagrams, string(buffer[:n]))
+	}
+	return datagrams
+}
+
+func TestWriteDatagrams(t *testing.T) {
+	listener := listenUdp(t)
+	conn, err := net.Dial("udp", listener.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var lines bytes.Buffer
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&lines, "transaction_success,step_name=step%d count=%di,sum=%d 1700000000000000000\n", i, i, i)
+	}
+	longLine := "long,tag=" + strings.Repeat("x", 2*maxUdpPayload) + " value=1\n"
+	lines.WriteString(longLine)
+	payload := lines.String()
+	if err := writeDatagrams(conn, lines.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	var received strings.Builder
+	for received.Len() < len(payload) {
+		datagram := readDatagrams(t, listener, 1)[0]
+		if !strings.HasSuffix(datagram, "\n") {
+			t.Errorf("datagram splits a line: %q", datagram[len(datagram)-20:])
+		}
+		if len(datagram) > maxUdpPayload && datagram != longLine {
+			t.Errorf("datagram of %d bytes exceeds %d", len(datagram), maxUdpPayload)
+		}
+		received.WriteString(datagram)
+	}
+	if received.String() != payload {
+		t.Errorf("received lines differ from sent ones")
+	}
+}
+
+func TestInfluxUdpSink(t *testing.T) {
+	listener := listenUdp(t)
+	sink, err := NewInfluxUdpSink(listener.LocalAddr().String(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range []float64{3, 1, 2} {
+		sink.Send(&Event{Name: "transaction_success", Type: TimingEvent, Value: value, Tags: []Tag{{"step_name", "get"}}})
+	}
+	sink.Send(&Event{Name: "users_running", Type: GaugeEvent, Value: 5})
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(readDatagrams(t, listener, 1)[0]), "\n")
+	sort.Strings(lines)
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "transaction_success,step_name=get count=3i,sum=6,min=1,max=3,mean=2 ") ||
+		!strings.HasPrefix(lines[1], "users_running value=5 ") {
+		t.Errorf("unexpected lines %q", lines)
+	}
+}
+
+func TestInfluxHttpSink(t *testing.T) {
+	requests := make(chan *http.Request, 1)
+	bodies := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- r
+		bodies <- string(body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sink := NewInfluxHttpSink(server.URL+"/write?db=ledokol", "secret", time.Hour)
+	sink.Send(&Event{Name: "transaction_retry", Type: CounterEvent, Value: 1})
+	sink.Send(&Event{Name: "transaction_retry", Type: CounterEvent, Value: 1})
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	request := <-requests
+	if request.URL.RequestURI() != "/write?db=ledokol" || request.Header.Get("Authorization") != "Token secret" {
+		t.Errorf("unexpected request %s with authorization %q", request.URL, request.Header.Get("Authorization"))
+	}
+	if body := <-bodies; !strings.HasPrefix(body, "transaction_retry value=2 ") {
+		t.Errorf("unexpected body %q", body)
+	}
+}
+
+func TestGraphiteSink(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var lines []string
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		received <- lines
+	}()
+
+	sink := NewGraphiteSink(listener.Addr().String(), "ledokol", time.Hour)
+	sink.Send(&Event{Name: "users_running", Type: GaugeEvent, Value: 2, Tags: []Tag{{"scenario_name", "main"}}})
+	sink.Send(&Event{Name: "users_running", Type: GaugeEvent, Value: 4, Tags: []Tag{{"scenario_name", "main"}}})
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case lines := <-received:
+		if len(lines) != 1 || !strings.HasPrefix(lines[0], "ledokol.users_running;scenario_name=main 4 ") {
+			t.Errorf("unexpected lines %q", lines)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("graphite listener received nothing")
+	}
+}
+
+func TestStatsdSink(t *testing.T) {
+	events := []*Event{
+		{Name: "transaction_success", Type: TimingEvent, Value: 12.5,
+			Tags: []Tag{{"step_name", "log in"}, {"test_name", "a:b|c"}}},
+		{Name: "users_running", Type: GaugeEvent, Value: 3, Tags: []Tag{{"scenario_name", "main.v2"}}},
+		{Name: "transaction_retry", Type: CounterEvent, Value: 1},
+	}
+	cases := []struct {
+		tags     bool
+		expected string
+	}{
+		{true, "ledokol.transaction_success:12.5|ms|#step_name:log_in,test_name:a_b_c\n" +
+			"ledokol.users_running:3|g|#scenario_name:main.v2\n" +
+			"ledokol.transaction_retry:1|c\n"},
+		{false, "ledokol.transaction_success.log_in.a_b_c:12.5|ms\n" +
+			"ledokol.users_running.main_v2:3|g\n" +
+			"ledokol.transaction_retry:1|c\n"},
+	}
+	for _, c := range cases {
+		listener := listenUdp(t)
+		sink, err := NewStatsdSink(listener.LocalAddr().String(), "ledokol", c.tags)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, event := range events {
+			sink.Send(event)
+		}
+		sink.Flush()
+		if datagram := readDatagrams(t, listener, 1)[0]; datagram != c.expected {
+			t.Errorf("tags %v: got\n%s\nexpected\n%s", c.tags, datagram, c.expected)
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkEmit измеряет стоимость событий на пути запроса: запрос порождает около восьми событий,
+// которые параллельно отправляют все пользователи
+func BenchmarkEmit(b *testing.B) {
+	sink := newAggregatingSink("benchmark", time.Hour, func([]*aggregate, time.Time) error { return nil })
+	SetSinks([]Sink{sink})
+	SetMetricLabels(true, "generator")
+	defer func() {
+		SetSinks(nil)
+		SetMetricLabels(true, "")
+		_ = sink.Close()
+	}()
+
+	steps := []string{"log in", "search", "add to cart", "checkout"}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			step := steps[i%len(steps)]
+			i++
+			emit(TimingEvent, "transaction_success", 12.5, "test_name", "test", "test_run_id", "run",
+				"script_name", "script", "step_name", step)
+		}
+	})
+}
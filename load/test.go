@@ -1,34 +1,239 @@
 package load
 
 import (
+	"fmt"
 	"net/http"
+	"net/http/cookiejar"
 	"sync"
 	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/ledokol-inc/ledokol/load/variables"
 )
 
 type Test struct {
-	Id        string
-	Name      string
-	Scenarios []*Scenario
-	Options   *TestOptions
+	Id              string
+	Name            string
+	Scenarios       []*Scenario
+	Options         *TestOptions
+	Variables       map[string]*variables.Variable
+	Setup           *Script
+	Teardown        *Script
+	sharedVariables map[string]string
+	transport       *http.Transport
+	results         *resultWriter
+	timeMutex       sync.Mutex
+	startTime       time.Time
+	endTime         time.Time
 }
 
 type TestOptions struct {
-	TotalDuration float64
+	TotalDuration  float64
+	DisableCookies bool
+	Transport      *TransportOptions
+	Tls            *TlsOptions
+	Results        *ResultsOptions
+	Metrics        *MetricsOptions
 }
 
-func (test *Test) PrepareTest() {
+func (test *Test) PrepareTest() error {
+	if err := test.prepareVariables(); err != nil {
+		return err
+	}
+	if err := test.Options.Metrics.validate(); err != nil {
+		return fmt.Errorf("test %s: metrics: %w", test.Name, err)
+	}
+	test.transport = newTransport(test.Options.Transport)
+	if test.Options.Tls != nil {
+		config, err := test.Options.Tls.config()
+		if err != nil {
+			return fmt.Errorf("test %s: tls: %w", test.Name, err)
+		}
+		test.transport.TLSClientConfig = config
+	}
+
+	setupVariables := make(map[string]bool)
+	if test.Setup != nil {
+		if err := test.prepareScript(test.Setup, nil); err != nil {
+			return err
+		}
+		setupVariables = test.Setup.knownVariables
+	}
+	if test.Teardown != nil {
+		if err := test.prepareScript(test.Teardown, setupVariables); err != nil {
+			return err
+		}
+	}
+
 	for i := range test.Scenarios {
-		test.Scenarios[i].PrepareScenario(test.Options.TotalDuration)
-		for _, step := range test.Scenarios[i].Script.Steps {
-			step.httpClient = &http.Client{
-				Timeout: time.Duration(step.Timeout) * time.Millisecond,
+		scenario := test.Scenarios[i]
+		if err := scenario.PrepareScenario(test.Options.TotalDuration); err != nil {
+			return err
+		}
+		scenario.test = test
+
+		userVariables := setupVariables
+		if scenario.OnStart != nil {
+			if err := test.prepareScript(scenario.OnStart, setupVariables); err != nil {
+				return err
+			}
+			userVariables = scenario.OnStart.knownVariables
+		}
+		for _, script := range scenario.userScripts {
+			if script == scenario.OnStart {
+				continue
+			}
+			if err := test.prepareScript(script, userVariables); err != nil {
+				return err
 			}
 		}
 	}
+	test.prepareReport()
+	if err := test.prepareLatencyMetrics(); err != nil {
+		return err
+	}
+	if err := test.prepareResults(); err != nil {
+		runLatencies.remove(test.Id)
+		return err
+	}
+	return nil
+}
+
+// prepareLatencyMetrics заводит метрики длительностей запуска с корзинами из настроек теста
+func (test *Test) prepareLatencyMetrics() error {
+	latency, err := runLatencies.add(test)
+	if err != nil {
+		return fmt.Errorf("test %s: metrics: %w", test.Name, err)
+	}
+	for _, script := range test.scripts() {
+		script.latency = latency
+	}
+	for _, scenario := range test.Scenarios {
+		scenario.latency = latency
+	}
+	return nil
+}
+
+func (test *Test) prepareResults() error {
+	if test.Options.Results == nil {
+		return nil
+	}
+	writer, err := newResultWriter(test.Options.Results, test.Id, test.Name)
+	if err != nil {
+		return fmt.Errorf("test %s: results: %w", test.Name, err)
+	}
+	test.results = writer
+	for _, script := range test.scripts() {
+		script.results = writer
+	}
+	return nil
+}
+
+func (test *Test) prepareScript(script *Script, externalVariables map[string]bool) error {
+	if err := script.PrepareScript(test.Variables, test.sharedVariables, externalVariables); err != nil {
+		return err
+	}
+	return forEachStep(script.Steps, func(step *Step) error {
+		if step.Tls == nil {
+			return nil
+		}
+		config, err := step.Tls.config()
+		if err != nil {
+			return fmt.Errorf("script %s, step %s: tls: %w", script.Name, step.Name, err)
+		}
+		step.transport = test.transport.Clone()
+		step.transport.TLSClientConfig = config
+		step.ownPools = test.perUserPool()
+		return nil
+	})
+}
+
+// newHttpClient создает клиента для пользователя. Если cookies не отключены, у каждого пользователя своя cookie jar,
+// общая для всех его шагов.
+func (test *Test) newHttpClient() *http.Client {
+	client := &http.Client{Transport: test.transport}
+	if test.perUserPool() {
+		client.Transport = test.transport.Clone()
+	}
+	if !test.Options.DisableCookies {
+		client.Jar, _ = cookiejar.New(nil)
+	}
+	return client
+}
+
+// prepareVariables готовит общие для всех сценариев переменные теста и один раз генерирует переменные с областью test
+func (test *Test) prepareVariables() error {
+	for name, variable := range test.Variables {
+		if err := variable.Prepare(name); err != nil {
+			return fmt.Errorf("test %s: %w", test.Name, err)
+		}
+	}
+
+	order, err := orderVariables(test.Variables, variableNames(test.Variables))
+	if err != nil {
+		return fmt.Errorf("test %s: %w", test.Name, err)
+	}
+
+	test.sharedVariables = make(map[string]string)
+	return generateVariables(test.Variables, order, variables.TestScope, initRand(), test.sharedVariables)
+}
+
+// releaseHttpClients закрывает пулы соединений пользователя, если они у него свои
+func (test *Test) releaseHttpClients(user *User) {
+	if !test.perUserPool() {
+		return
+	}
+	user.httpClient.CloseIdleConnections()
+	for _, client := range user.stepClients {
+		client.CloseIdleConnections()
+	}
+}
+
+// closeIdleConnections закрывает общие пулы соединений теста и шагов с собственными настройками TLS
+func (test *Test) closeIdleConnections() {
+	test.transport.CloseIdleConnections()
+	for _, script := range test.scripts() {
+		_ = forEachStep(script.Steps, func(step *Step) error {
+			if step.transport != nil {
+				step.transport.CloseIdleConnections()
+			}
+			return nil
+		})
+	}
+}
+
+func (test *Test) perUserPool() bool {
+	return test.Options.Transport != nil && test.Options.Transport.PerUserPool
 }
 
 func (test *Test) Run() {
+	test.timeMutex.Lock()
+	test.startTime = time.Now()
+	test.timeMutex.Unlock()
+	defer func() {
+		test.timeMutex.Lock()
+		test.endTime = time.Now()
+		test.timeMutex.Unlock()
+	}()
+	// отложенные вызовы выполняются в обратном порядке: последняя отправка remote-write идет до того, как
+	// запуск помечается завершенным и его серии могут быть удалены при сборе метрик
+	defer markRunFinished(test.Id)
+	defer remoteWrite.startPushing(test.Id).stopPushing()
+	defer FlushSinks()
+	defer test.results.close()
+
+	var setupValues map[string]string
+	if test.Setup != nil {
+		var success bool
+		if setupValues, success = test.runHook(test.Setup, nil); !success {
+			log.Error().Str("testRunId", test.Id).Msg("Подготовка теста завершилась с ошибкой, сценарии не будут запущены")
+			test.runTeardown(setupValues)
+			return
+		}
+		test.shareSetupValues(setupValues)
+	}
+
 	testWait := &sync.WaitGroup{}
 	for i := range test.Scenarios {
 		testWait.Add(1)
@@ -38,6 +243,9 @@ func (test *Test) Run() {
 		}(test.Scenarios[i], test.Name, test.Id)
 	}
 	testWait.Wait()
+
+	test.runTeardown(setupValues)
+	test.closeIdleConnections()
 }
 
 func (test *Test) Stop() {
@@ -0,0 +1,57 @@
+package load
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TlsOptions задают TLS для теста или отдельного шага. Cert, Key и Ca передаются в формате PEM,
+// MinVersion - одно из 1.0, 1.1, 1.2, 1.3.
+type TlsOptions struct {
+	Cert               string
+	Key                string
+	Ca                 string
+	InsecureSkipVerify bool
+	ServerName         string
+	MinVersion         string
+}
+
+func (options *TlsOptions) config() (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: options.InsecureSkipVerify,
+		ServerName:         options.ServerName,
+	}
+
+	if options.Cert != "" || options.Key != "" {
+		certificate, err := tls.X509KeyPair([]byte(options.Cert), []byte(options.Key))
+		if err != nil {
+			return nil, fmt.Errorf("can't load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	if options.Ca != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(options.Ca)) {
+			return nil, fmt.Errorf("can't read CA certificates")
+		}
+		config.RootCAs = pool
+	}
+
+	if options.MinVersion != "" {
+		version, ok := tlsVersions[options.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS version %s", options.MinVersion)
+		}
+		config.MinVersion = version
+	}
+	return config, nil
+}
@@ -0,0 +1,81 @@
+package load
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+const (
+	dnsPhase       = "dns"
+	connectPhase   = "connect"
+	tlsPhase       = "tls"
+	firstBytePhase = "first_byte"
+	bodyReadPhase  = "body_read"
+)
+
+// requestTrace собирает длительности этапов запроса. Этапы, которых не было (например, при переиспользовании
+// соединения), не попадают в метрики. first_byte - ожидание ответа сервера от отправки запроса до первого байта
+// ответа, без dns, connect и tls.
+type requestTrace struct {
+	mutex        sync.Mutex
+	dnsStart     time.Time
+	connectStart time.Time
+	tlsStart     time.Time
+	wroteRequest time.Time
+	phases       map[string]time.Duration
+}
+
+func newRequestTrace() *requestTrace {
+	return &requestTrace{phases: make(map[string]time.Duration)}
+}
+
+func (trace *requestTrace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { trace.begin(&trace.dnsStart) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { trace.end(&trace.dnsStart, dnsPhase) },
+		ConnectStart: func(string, string) {
+			trace.begin(&trace.connectStart)
+		},
+		ConnectDone: func(string, string, error) {
+			trace.end(&trace.connectStart, connectPhase)
+		},
+		TLSHandshakeStart: func() { trace.begin(&trace.tlsStart) },
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			trace.end(&trace.tlsStart, tlsPhase)
+		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { trace.begin(&trace.wroteRequest) },
+		GotFirstResponseByte: func() { trace.end(&trace.wroteRequest, firstBytePhase) },
+	}
+}
+
+func (trace *requestTrace) begin(start *time.Time) {
+	trace.mutex.Lock()
+	*start = time.Now()
+	trace.mutex.Unlock()
+}
+
+func (trace *requestTrace) end(start *time.Time, phase string) {
+	trace.mutex.Lock()
+	if !start.IsZero() {
+		trace.phases[phase] = time.Since(*start)
+	}
+	trace.mutex.Unlock()
+}
+
+func (trace *requestTrace) setBodyRead(duration time.Duration) {
+	trace.mutex.Lock()
+	trace.phases[bodyReadPhase] = duration
+	trace.mutex.Unlock()
+}
+
+func (trace *requestTrace) observe(latency *latencyMetrics, iter *iteration, scriptName string, stepName string) {
+	trace.mutex.Lock()
+	defer trace.mutex.Unlock()
+	for phase, duration := range trace.phases {
+		latency.observePhase(duration, runLabelValues(iter.testName, iter.testRunId, scriptName, stepName, phase)...)
+		emit(TimingEvent, "transaction_phase", durationMillis(duration), "test_name", iter.testName,
+			"test_run_id", iter.testRunId, "script_name", scriptName, "step_name", stepName, "phase", phase)
+	}
+}
@@ -0,0 +1,45 @@
+package load
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"testing"
+	"time"
+)
+
+func TestFirstBytePhaseExcludesConnection(t *testing.T) {
+	const dialDelay = 300 * time.Millisecond
+	const serverDelay = 50 * time.Millisecond
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(serverDelay)
+	}))
+	defer target.Close()
+
+	dialer := &net.Dialer{}
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network string, address string) (net.Conn, error) {
+			time.Sleep(dialDelay)
+			return dialer.DialContext(ctx, network, address)
+		},
+	}}
+	trace := newRequestTrace()
+	req, _ := http.NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace.clientTrace()),
+		http.MethodGet, target.URL, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	firstByte, measured := trace.phases[firstBytePhase]
+	if !measured || firstByte < serverDelay || firstByte >= dialDelay {
+		t.Errorf("first byte phase %v must include server wait %v and exclude connection %v", firstByte,
+			serverDelay, dialDelay)
+	}
+	if _, measured := trace.phases[connectPhase]; !measured {
+		t.Errorf("connect phase is not measured")
+	}
+}
@@ -0,0 +1,61 @@
+package load
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+)
+
+const defaultDialKeepAlive = 30 * time.Second
+
+// TransportOptions настраивают пул соединений теста. Таймауты задаются в миллисекундах, нулевые значения
+// оставляют настройки http.DefaultTransport. При PerUserPool у каждого пользователя свой пул соединений.
+type TransportOptions struct {
+	MaxIdleConns          int
+	MaxIdleConnsPerHost   int
+	MaxConnsPerHost       int
+	DisableKeepAlives     bool
+	PerUserPool           bool
+	DialTimeout           int64
+	TlsHandshakeTimeout   int64
+	ResponseHeaderTimeout int64
+	IdleConnTimeout       int64
+	DisableHttp2          bool
+}
+
+func newTransport(options *TransportOptions) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if options == nil {
+		return transport
+	}
+
+	if options.MaxIdleConns > 0 {
+		transport.MaxIdleConns = options.MaxIdleConns
+	}
+	if options.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = options.MaxIdleConnsPerHost
+	}
+	transport.MaxConnsPerHost = options.MaxConnsPerHost
+	transport.DisableKeepAlives = options.DisableKeepAlives
+	if options.DialTimeout > 0 {
+		dialer := &net.Dialer{Timeout: millis(options.DialTimeout), KeepAlive: defaultDialKeepAlive}
+		transport.DialContext = dialer.DialContext
+	}
+	if options.TlsHandshakeTimeout > 0 {
+		transport.TLSHandshakeTimeout = millis(options.TlsHandshakeTimeout)
+	}
+	transport.ResponseHeaderTimeout = millis(options.ResponseHeaderTimeout)
+	if options.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = millis(options.IdleConnTimeout)
+	}
+	if options.DisableHttp2 {
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = make(map[string]func(authority string, c *tls.Conn) http.RoundTripper)
+	}
+	return transport
+}
+
+func millis(value int64) time.Duration {
+	return time.Duration(value) * time.Millisecond
+}
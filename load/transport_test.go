@@ -0,0 +1,41 @@
+package load
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestStepTransportFollowsPerUserPool(t *testing.T) {
+	for _, perUserPool := range []bool{false, true} {
+		script := &Script{Name: "tls", Steps: []*Step{
+			{Name: "plain", Url: "https://localhost"},
+			{Name: "own tls", Url: "https://localhost", Tls: &TlsOptions{ServerName: "localhost"}},
+		}}
+		test := &Test{Id: "transport-run", Name: "transport", Setup: script,
+			Options: &TestOptions{Transport: &TransportOptions{PerUserPool: perUserPool}}}
+		if err := test.PrepareTest(); err != nil {
+			t.Fatal(err)
+		}
+
+		first, _ := CreateUser(test.newHttpClient(), script)
+		second, _ := CreateUser(test.newHttpClient(), script)
+		plain, ownTls := script.Steps[0], script.Steps[1]
+		if plain.httpClient(first) != first.httpClient {
+			t.Errorf("per user pool %v: step without tls must use the user client", perUserPool)
+		}
+		firstTransport := ownTls.httpClient(first).Transport.(*http.Transport)
+		secondTransport := ownTls.httpClient(second).Transport.(*http.Transport)
+		if ownTls.httpClient(first) != ownTls.httpClient(first) {
+			t.Errorf("per user pool %v: step client must be reused by the user", perUserPool)
+		}
+		if firstTransport.TLSClientConfig.ServerName != "localhost" {
+			t.Errorf("per user pool %v: step transport must use step tls options", perUserPool)
+		}
+		if (firstTransport != secondTransport) != perUserPool {
+			t.Errorf("per user pool %v: users share step transport: %v", perUserPool, firstTransport == secondTransport)
+		}
+		test.releaseHttpClients(first)
+		test.releaseHttpClients(second)
+		test.closeIdleConnections()
+	}
+}
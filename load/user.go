@@ -2,6 +2,8 @@ package load
 
 import (
 	"math/rand"
+	"net/http"
+	"time"
 
 	"github.com/ledokol-inc/ledokol/load/variables"
 )
@@ -12,16 +14,48 @@ type User struct {
 	scriptVariables map[string]string
 	id              string
 	userRand        *rand.Rand
+	started         bool
+	httpClient      *http.Client
+	stepClients     map[*Step]*http.Client
+	stop            chan struct{}
+	interrupt       <-chan struct{}
 }
 
-func CreateUser(script *Script) *User {
+func CreateUser(httpClient *http.Client, scripts ...*Script) (*User, error) {
 
-	user := &User{scriptVariables: make(map[string]string), userRand: initRand()}
+	user := &User{scriptVariables: make(map[string]string), userRand: initRand(), httpClient: httpClient,
+		stepClients: make(map[*Step]*http.Client)}
 	user.id = randomId(user.userRand, userIdLength)
-	script.generateVariablesForStage(user, variables.ScenarioScope)
-	return user
+	for _, script := range scripts {
+		for name, value := range script.sharedValues {
+			user.scriptVariables[name] = value
+		}
+	}
+	for _, script := range scripts {
+		if err := script.generateVariablesForStage(user, variables.ScenarioScope); err != nil {
+			return user, err
+		}
+	}
+	return user, nil
 }
 
 func initRand() *rand.Rand {
 	return rand.New(rand.NewSource(rand.Int63()))
 }
+
+// sleep ждет duration и возвращает false, если пользователь или тест были остановлены раньше
+func (user *User) sleep(duration time.Duration) bool {
+	if duration <= 0 {
+		return true
+	}
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-user.stop:
+		return false
+	case <-user.interrupt:
+		return false
+	}
+}
@@ -0,0 +1,222 @@
+package variables
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+type FeederFormat string
+
+const (
+	CsvFormat   FeederFormat = "csv"
+	JsonlFormat FeederFormat = "jsonl"
+)
+
+type Distribution string
+
+const (
+	SequentialDistribution Distribution = "sequential"
+	RandomDistribution     Distribution = "random"
+	UniqueDistribution     Distribution = "unique"
+)
+
+type ExhaustedPolicy string
+
+const (
+	RecyclePolicy  ExhaustedPolicy = "recycle"
+	StopUserPolicy ExhaustedPolicy = "stopUser"
+	StopTestPolicy ExhaustedPolicy = "stopTest"
+)
+
+var (
+	ErrStopUser = errors.New("data feeder is exhausted, user must be stopped")
+	ErrStopTest = errors.New("data feeder is exhausted, test must be stopped")
+)
+
+// Feeder берет значения переменной из строк CSV (с заголовком) или JSONL файла.
+// Каждая колонка строки доступна как <имя переменной>.<колонка>, а значение Column (по умолчанию первая колонка) -
+// как сама переменная. При unique распределении пользователь получает одну строку на все время работы.
+type Feeder struct {
+	File         string
+	Format       FeederFormat
+	Distribution Distribution
+	OnExhausted  ExhaustedPolicy `mapstructure:"onExhausted" json:"onExhausted"`
+	Column       string
+	columns      []string
+	rows         []map[string]string
+	nextRow      uint64
+}
+
+var dataDirectory = "."
+
+// SetDataDirectory задает каталог, внутри которого ищутся файлы Feeder, вызывается до запуска тестов
+func SetDataDirectory(directory string) {
+	dataDirectory = directory
+}
+
+// ResolveFile возвращает путь к файлу name внутри каталога directory. Абсолютные пути и выход из каталога через ..
+// запрещены, так как имя файла приходит в запросе на запуск теста.
+func ResolveFile(directory string, name string) (string, error) {
+	cleaned := filepath.Clean(name)
+	if name == "" || filepath.IsAbs(name) || cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("file %q must be a relative path inside %s", name, directory)
+	}
+	return filepath.Join(directory, cleaned), nil
+}
+
+func (feeder *Feeder) Load() error {
+	if err := feeder.validate(); err != nil {
+		return err
+	}
+	path, err := ResolveFile(dataDirectory, feeder.File)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	format := feeder.Format
+	if format == "" {
+		format = FeederFormat(strings.TrimPrefix(filepath.Ext(feeder.File), "."))
+	}
+	switch format {
+	case CsvFormat:
+		err = feeder.readCsv(file)
+	case JsonlFormat:
+		err = feeder.readJsonl(file)
+	default:
+		err = fmt.Errorf("unknown feeder format %q", format)
+	}
+	if err != nil {
+		return fmt.Errorf("feeder %s: %w", feeder.File, err)
+	}
+
+	if len(feeder.rows) == 0 {
+		return fmt.Errorf("feeder %s has no rows", feeder.File)
+	}
+	if feeder.Column == "" {
+		feeder.Column = feeder.columns[0]
+	}
+	for _, column := range feeder.columns {
+		if column == feeder.Column {
+			return nil
+		}
+	}
+	return fmt.Errorf("feeder %s has no column %s", feeder.File, feeder.Column)
+}
+
+func (feeder *Feeder) validate() error {
+	switch feeder.Distribution {
+	case "", SequentialDistribution, RandomDistribution, UniqueDistribution:
+	default:
+		return fmt.Errorf("unknown feeder distribution %q", feeder.Distribution)
+	}
+	switch feeder.OnExhausted {
+	case "", RecyclePolicy, StopUserPolicy, StopTestPolicy:
+	default:
+		return fmt.Errorf("unknown feeder exhausted policy %q", feeder.OnExhausted)
+	}
+	return nil
+}
+
+func (feeder *Feeder) readCsv(reader io.Reader) error {
+	records, err := csv.NewReader(reader).ReadAll()
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return fmt.Errorf("header is missing")
+	}
+	feeder.columns = records[0]
+	for _, record := range records[1:] {
+		row := make(map[string]string, len(record))
+		for i, value := range record {
+			row[feeder.columns[i]] = value
+		}
+		feeder.rows = append(feeder.rows, row)
+	}
+	return nil
+}
+
+func (feeder *Feeder) readJsonl(reader io.Reader) error {
+	knownColumns := make(map[string]bool)
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var object map[string]interface{}
+		decoder := json.NewDecoder(strings.NewReader(line))
+		decoder.UseNumber()
+		if err := decoder.Decode(&object); err != nil {
+			return err
+		}
+		row := make(map[string]string, len(object))
+		for key, value := range object {
+			if !knownColumns[key] {
+				knownColumns[key] = true
+				feeder.columns = append(feeder.columns, key)
+			}
+			if str, ok := value.(string); ok {
+				row[key] = str
+			} else {
+				encoded, _ := json.Marshal(value)
+				row[key] = string(encoded)
+			}
+		}
+		feeder.rows = append(feeder.rows, row)
+	}
+	return scanner.Err()
+}
+
+func (feeder *Feeder) Names(variableName string) []string {
+	names := make([]string, 0, len(feeder.columns))
+	for _, column := range feeder.columns {
+		names = append(names, variableName+"."+column)
+	}
+	return names
+}
+
+func (feeder *Feeder) Feed(variableName string, userRand *rand.Rand, values map[string]string) error {
+	if feeder.Distribution == UniqueDistribution {
+		if _, ok := values[variableName]; ok {
+			return nil
+		}
+	}
+
+	var row map[string]string
+	if feeder.Distribution == RandomDistribution {
+		row = feeder.rows[userRand.Intn(len(feeder.rows))]
+	} else {
+		index := atomic.AddUint64(&feeder.nextRow, 1) - 1
+		if index >= uint64(len(feeder.rows)) {
+			switch feeder.OnExhausted {
+			case StopUserPolicy:
+				return ErrStopUser
+			case StopTestPolicy:
+				return ErrStopTest
+			}
+		}
+		row = feeder.rows[index%uint64(len(feeder.rows))]
+	}
+
+	for _, column := range feeder.columns {
+		values[variableName+"."+column] = row[column]
+	}
+	values[variableName] = row[feeder.Column]
+	return nil
+}
@@ -0,0 +1,71 @@
+package variables
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFeederJsonlKeepsNumbers(t *testing.T) {
+	directory := t.TempDir()
+	SetDataDirectory(directory)
+	defer SetDataDirectory(".")
+	content := `{"id": 12345678901234567890, "price": 0.1, "tags": [1, 2], "name": "first"}` + "\n"
+	if err := os.WriteFile(filepath.Join(directory, "users.jsonl"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	feeder := &Feeder{File: "users.jsonl", Column: "id"}
+	if err := feeder.Load(); err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]string)
+	if err := feeder.Feed("user", rand.New(rand.NewSource(1)), values); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"user": "12345678901234567890", "user.id": "12345678901234567890",
+		"user.price": "0.1", "user.tags": "[1,2]", "user.name": "first"}
+	for name, value := range expected {
+		if values[name] != value {
+			t.Errorf("%s: got %q, expected %q", name, values[name], value)
+		}
+	}
+}
+
+func TestFeederValidate(t *testing.T) {
+	for _, feeder := range []*Feeder{
+		{File: "users.csv", Distribution: "shuffle"},
+		{File: "users.csv", OnExhausted: "stop_test"},
+		{File: "/etc/passwd", Format: CsvFormat},
+		{File: "../config.yaml", Format: CsvFormat},
+		{File: "data/../../config.yaml", Format: CsvFormat},
+	} {
+		if err := feeder.Load(); err == nil {
+			t.Errorf("%+v: expected error", feeder)
+		}
+	}
+}
+
+func TestResolveFile(t *testing.T) {
+	cases := []struct {
+		name     string
+		expected string
+	}{
+		{"users.csv", filepath.Join("data", "users.csv")},
+		{"sub/../users.csv", filepath.Join("data", "users.csv")},
+		{"sub/users.csv", filepath.Join("data", "sub", "users.csv")},
+		{"..users.csv", filepath.Join("data", "..users.csv")},
+	}
+	for _, c := range cases {
+		path, err := ResolveFile("data", c.name)
+		if err != nil || path != c.expected {
+			t.Errorf("%s: got %q, %v, expected %q", c.name, path, err, c.expected)
+		}
+	}
+	for _, name := range []string{"", "..", "../users.csv", "/tmp/users.csv", "a/../../users.csv"} {
+		if path, err := ResolveFile("data", name); err == nil {
+			t.Errorf("%s: expected error, got %q", name, path)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package variables
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	unixTimestamp      = "unix"
+	unixMilliTimestamp = "unixMilli"
+	unixNanoTimestamp  = "unixNano"
+)
+
+func (variable *Variable) prepareGenerator(name string) error {
+	switch variable.Type {
+	case IntType, FloatType:
+		if variable.Max < variable.Min {
+			return fmt.Errorf("variable %s: max is less than min", name)
+		}
+	case CounterType:
+		if variable.Increment == 0 {
+			variable.Increment = 1
+		}
+		atomic.StoreInt64(&variable.counter, variable.Start-variable.Increment)
+	case ListType:
+		if len(variable.Values) == 0 {
+			return fmt.Errorf("variable %s: values are not set", name)
+		}
+		if len(variable.Weights) != 0 && len(variable.Weights) != len(variable.Values) {
+			return fmt.Errorf("variable %s: weights count differs from values count", name)
+		}
+		variable.cumulativeWeights = make([]float64, len(variable.Values))
+		sum := 0.0
+		for i := range variable.Values {
+			weight := 1.0
+			if len(variable.Weights) != 0 {
+				weight = variable.Weights[i]
+			}
+			if weight < 0 {
+				return fmt.Errorf("variable %s: negative weight %v", name, weight)
+			}
+			sum += weight
+			variable.cumulativeWeights[i] = sum
+		}
+		if sum == 0 {
+			return fmt.Errorf("variable %s: sum of weights is zero", name)
+		}
+	case DerivedType:
+		template, err := ParseTemplate(variable.Expression)
+		if err != nil {
+			return fmt.Errorf("variable %s: %w", name, err)
+		}
+		variable.expression = template
+	}
+	return nil
+}
+
+func (variable *Variable) generateValue(name string, userRand *rand.Rand, values map[string]string) string {
+	switch variable.Type {
+	case UuidType:
+		return generateUuid(userRand)
+	case CounterType:
+		if variable.Global {
+			return strconv.FormatInt(atomic.AddInt64(&variable.counter, variable.Increment), 10)
+		}
+		previous, err := strconv.ParseInt(values[name], 10, 64)
+		if err != nil {
+			return strconv.FormatInt(variable.Start, 10)
+		}
+		return strconv.FormatInt(previous+variable.Increment, 10)
+	case TimestampType:
+		return formatTimestamp(time.Now(), variable.Format)
+	case IntType:
+		min, max := int64(variable.Min), int64(variable.Max)
+		return strconv.FormatInt(min+userRand.Int63n(max-min+1), 10)
+	case FloatType:
+		value := variable.Min + userRand.Float64()*(variable.Max-variable.Min)
+		return strconv.FormatFloat(value, 'f', variable.Precision, 64)
+	case ListType:
+		point := userRand.Float64() * variable.cumulativeWeights[len(variable.cumulativeWeights)-1]
+		for i, weight := range variable.cumulativeWeights {
+			if point < weight {
+				return variable.Values[i]
+			}
+		}
+		return variable.Values[len(variable.Values)-1]
+	case DerivedType:
+		return variable.expression.Execute(values)
+	}
+	return ""
+}
+
+func generateUuid(userRand *rand.Rand) string {
+	b := make([]byte, 16)
+	userRand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+func formatTimestamp(now time.Time, format string) string {
+	switch format {
+	case "":
+		return now.Format(time.RFC3339)
+	case unixTimestamp:
+		return strconv.FormatInt(now.Unix(), 10)
+	case unixMilliTimestamp:
+		return strconv.FormatInt(now.UnixMilli(), 10)
+	case unixNanoTimestamp:
+		return strconv.FormatInt(now.UnixNano(), 10)
+	default:
+		return now.Format(format)
+	}
+}
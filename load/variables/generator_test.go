@@ -0,0 +1,158 @@
+package variables
+
+import (
+	"math/rand"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestGenerateCounter(t *testing.T) {
+	cases := []struct {
+		variable *Variable
+		expected []string
+	}{
+		{&Variable{Type: CounterType}, []string{"0", "1", "2"}},
+		{&Variable{Type: CounterType, Start: 10, Increment: 5}, []string{"10", "15", "20"}},
+		{&Variable{Type: CounterType, Start: 3, Increment: -1}, []string{"3", "2", "1"}},
+	}
+	for i, c := range cases {
+		if err := c.variable.Prepare("counter"); err != nil {
+			t.Fatal(err)
+		}
+		first, second := make(map[string]string), make(map[string]string)
+		for j, expected := range c.expected {
+			for _, values := range []map[string]string{first, second} {
+				if err := c.variable.Generate("counter", nil, values); err != nil {
+					t.Fatal(err)
+				}
+				if values["counter"] != expected {
+					t.Errorf("case %d, value %d: got %q, expected %q", i, j, values["counter"], expected)
+				}
+			}
+		}
+	}
+}
+
+func TestGenerateGlobalCounter(t *testing.T) {
+	variable := &Variable{Type: CounterType, Start: 100, Increment: 2, Global: true}
+	if err := variable.Prepare("counter"); err != nil {
+		t.Fatal(err)
+	}
+	first, second := make(map[string]string), make(map[string]string)
+	var got []string
+	for i := 0; i < 2; i++ {
+		for _, values := range []map[string]string{first, second} {
+			if err := variable.Generate("counter", nil, values); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, values["counter"])
+		}
+	}
+	for i, expected := range []string{"100", "102", "104", "106"} {
+		if got[i] != expected {
+			t.Errorf("value %d: got %q, expected %q", i, got[i], expected)
+		}
+	}
+}
+
+func TestGenerateRanges(t *testing.T) {
+	userRand := rand.New(rand.NewSource(1))
+	cases := []struct {
+		variable *Variable
+		pattern  string
+		min, max float64
+	}{
+		{&Variable{Type: IntType, Min: -2, Max: 2}, `^-?\d$`, -2, 2},
+		{&Variable{Type: IntType, Min: 5, Max: 5}, `^5$`, 5, 5},
+		{&Variable{Type: FloatType, Min: 1, Max: 2, Precision: 2}, `^\d\.\d{2}$`, 1, 2},
+		{&Variable{Type: FloatType, Min: 0, Max: 100}, `^\d+$`, 0, 100},
+	}
+	for i, c := range cases {
+		if err := c.variable.Prepare("value"); err != nil {
+			t.Fatal(err)
+		}
+		seen := make(map[string]bool)
+		for j := 0; j < 1000; j++ {
+			value := c.variable.generateValue("value", userRand, nil)
+			number, err := strconv.ParseFloat(value, 64)
+			if err != nil || !regexp.MustCompile(c.pattern).MatchString(value) || number < c.min || number > c.max {
+				t.Fatalf("case %d: unexpected value %q", i, value)
+			}
+			seen[value] = true
+		}
+		if c.variable.Type == IntType && len(seen) != int(c.max-c.min)+1 {
+			t.Errorf("case %d: got %d distinct values of %v", i, len(seen), c.max-c.min+1)
+		}
+	}
+}
+
+func TestGenerateWeightedList(t *testing.T) {
+	variable := &Variable{Type: ListType, Values: []string{"a", "b", "c"}, Weights: []float64{1, 0, 3}}
+	if err := variable.Prepare("list"); err != nil {
+		t.Fatal(err)
+	}
+	userRand := rand.New(rand.NewSource(1))
+	counts := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		counts[variable.generateValue("list", userRand, nil)]++
+	}
+	if counts["b"] != 0 || counts["a"] < 2200 || counts["a"] > 2800 || counts["c"] < 7200 || counts["c"] > 7800 {
+		t.Errorf("unexpected distribution %v", counts)
+	}
+}
+
+func TestPrepareGeneratorErrors(t *testing.T) {
+	for i, variable := range []*Variable{
+		{Type: IntType, Min: 2, Max: 1},
+		{Type: FloatType, Min: 2, Max: 1},
+		{Type: ListType},
+		{Type: ListType, Values: []string{"a", "b"}, Weights: []float64{1}},
+		{Type: ListType, Values: []string{"a"}, Weights: []float64{-1}},
+		{Type: ListType, Values: []string{"a", "b"}, Weights: []float64{0, 0}},
+		{Type: DerivedType, Expression: "${unclosed"},
+		{Type: "random"},
+	} {
+		if err := variable.Prepare("invalid"); err == nil {
+			t.Errorf("case %d: expected error for %+v", i, variable)
+		}
+	}
+}
+
+func TestFormatTimestamp(t *testing.T) {
+	now := time.Date(2023, 11, 14, 22, 13, 20, 123456789, time.UTC)
+	cases := []struct {
+		format   string
+		expected string
+	}{
+		{"", "2023-11-14T22:13:20Z"},
+		{unixTimestamp, "1700000000"},
+		{unixMilliTimestamp, "1700000000123"},
+		{unixNanoTimestamp, "1700000000123456789"},
+		{"2006-01-02", "2023-11-14"},
+	}
+	for _, c := range cases {
+		if value := formatTimestamp(now, c.format); value != c.expected {
+			t.Errorf("format %q: got %q, expected %q", c.format, value, c.expected)
+		}
+	}
+}
+
+func TestGenerateDerived(t *testing.T) {
+	variable := &Variable{Type: DerivedType, Expression: "user-${id}@${domain}"}
+	if err := variable.Prepare("email"); err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]string{"id": "42", "domain": "example.com"}
+	if err := variable.Generate("email", nil, values); err != nil {
+		t.Fatal(err)
+	}
+	if values["email"] != "user-42@example.com" {
+		t.Errorf("unexpected value %q", values["email"])
+	}
+	dependencies := variable.Dependencies()
+	if len(dependencies) != 2 || dependencies[0] != "id" || dependencies[1] != "domain" {
+		t.Errorf("unexpected dependencies %v", dependencies)
+	}
+}
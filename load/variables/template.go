@@ -0,0 +1,83 @@
+package variables
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	templateOpen   = "${"
+	templateClose  = "}"
+	templateEscape = "$${"
+)
+
+// Template - строка с подстановками вида ${name}. Последовательность $${ выводится как ${.
+type Template struct {
+	parts []templatePart
+}
+
+type templatePart struct {
+	text       string
+	isVariable bool
+}
+
+func ParseTemplate(text string) (*Template, error) {
+	template := &Template{}
+	var literal strings.Builder
+	for len(text) > 0 {
+		if strings.HasPrefix(text, templateEscape) {
+			literal.WriteString(templateOpen)
+			text = text[len(templateEscape):]
+			continue
+		}
+		if !strings.HasPrefix(text, templateOpen) {
+			literal.WriteByte(text[0])
+			text = text[1:]
+			continue
+		}
+
+		end := strings.Index(text, templateClose)
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed %s in template", templateOpen)
+		}
+		name := strings.TrimSpace(text[len(templateOpen):end])
+		if name == "" {
+			return nil, fmt.Errorf("empty variable name in template")
+		}
+		if literal.Len() > 0 {
+			template.parts = append(template.parts, templatePart{text: literal.String()})
+			literal.Reset()
+		}
+		template.parts = append(template.parts, templatePart{text: name, isVariable: true})
+		text = text[end+len(templateClose):]
+	}
+	if literal.Len() > 0 {
+		template.parts = append(template.parts, templatePart{text: literal.String()})
+	}
+	return template, nil
+}
+
+func (template *Template) Execute(values map[string]string) string {
+	if len(template.parts) == 1 && !template.parts[0].isVariable {
+		return template.parts[0].text
+	}
+	var result strings.Builder
+	for _, part := range template.parts {
+		if part.isVariable {
+			result.WriteString(values[part.text])
+		} else {
+			result.WriteString(part.text)
+		}
+	}
+	return result.String()
+}
+
+func (template *Template) Variables() []string {
+	var names []string
+	for _, part := range template.parts {
+		if part.isVariable {
+			names = append(names, part.text)
+		}
+	}
+	return names
+}
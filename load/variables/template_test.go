@@ -0,0 +1,45 @@
+package variables
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTemplate(t *testing.T) {
+	values := map[string]string{"id": "42", "name": "bob"}
+	cases := []struct {
+		text      string
+		expected  string
+		variables []string
+	}{
+		{"", "", nil},
+		{"plain text", "plain text", nil},
+		{"${id}", "42", []string{"id"}},
+		{"/users/${id}/name/${ name }?x=1", "/users/42/name/bob?x=1", []string{"id", "name"}},
+		{"cost $5 and ${id}", "cost $5 and 42", []string{"id"}},
+		{"literal $${id} and ${id}", "literal ${id} and 42", []string{"id"}},
+		{"${unknown}-", "-", []string{"unknown"}},
+		{"{${id}}", "{42}", []string{"id"}},
+	}
+	for _, c := range cases {
+		template, err := ParseTemplate(c.text)
+		if err != nil {
+			t.Errorf("%q: %v", c.text, err)
+			continue
+		}
+		if result := template.Execute(values); result != c.expected {
+			t.Errorf("%q: got %q, expected %q", c.text, result, c.expected)
+		}
+		if variables := template.Variables(); !reflect.DeepEqual(variables, c.variables) {
+			t.Errorf("%q: got variables %v, expected %v", c.text, variables, c.variables)
+		}
+	}
+}
+
+func TestParseTemplateErrors(t *testing.T) {
+	for _, text := range []string{"${id", "a ${} b", "${   }"} {
+		if _, err := ParseTemplate(text); err == nil {
+			t.Errorf("%q: expected error", text)
+		}
+	}
+}
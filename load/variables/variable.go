@@ -1,6 +1,7 @@
 package variables
 
 import (
+	"fmt"
 	"math/rand"
 	"regexp"
 	"regexp/syntax"
@@ -16,15 +17,98 @@ const (
 	IterationScope Scope = "iteration"
 	StepScope      Scope = "step"
 	ScenarioScope  Scope = "scenario"
+	TestScope      Scope = "test"
 )
 
+type Type string
+
+const (
+	RegexType     Type = "regex"
+	FeederType    Type = "feeder"
+	UuidType      Type = "uuid"
+	CounterType   Type = "counter"
+	TimestampType Type = "timestamp"
+	IntType       Type = "int"
+	FloatType     Type = "float"
+	ListType      Type = "list"
+	DerivedType   Type = "derived"
+)
+
+// Variable описывает способ генерации значения переменной. Какие поля используются, зависит от Type:
+// Min и Max для int и float (Precision - число знаков после запятой), Start и Increment для counter
+// (Global - один счетчик на всех пользователей), Format для timestamp (layout Go, unix, unixMilli или unixNano),
+// Values и Weights для list, Expression с подстановками ${name} для derived.
 type Variable struct {
-	Name            string
-	Scope           Scope
-	GenerationRegex *syntax.Regexp `mapstructure:"generationRegex" json:"generationRegex"`
-	InsertingRegex  *regexp.Regexp
+	Name              string
+	Scope             Scope
+	Type              Type
+	GenerationRegex   *syntax.Regexp `mapstructure:"generationRegex" json:"generationRegex"`
+	InsertingRegex    *regexp.Regexp
+	Feeder            *Feeder
+	Min               float64
+	Max               float64
+	Precision         int
+	Start             int64
+	Increment         int64
+	Global            bool
+	Format            string
+	Values            []string
+	Weights           []float64
+	Expression        string
+	counter           int64
+	cumulativeWeights []float64
+	expression        *Template
+}
+
+func (variable *Variable) Prepare(name string) error {
+	if variable.Type == "" {
+		variable.Type = RegexType
+	}
+	switch variable.Type {
+	case RegexType:
+		return nil
+	case FeederType:
+		if variable.Feeder == nil {
+			return fmt.Errorf("variable %s: feeder is not set", name)
+		}
+		if err := variable.Feeder.Load(); err != nil {
+			return fmt.Errorf("variable %s: %w", name, err)
+		}
+		return nil
+	case UuidType, CounterType, TimestampType, IntType, FloatType, ListType, DerivedType:
+		return variable.prepareGenerator(name)
+	default:
+		return fmt.Errorf("variable %s: unknown type %q", name, variable.Type)
+	}
+}
+
+func (variable *Variable) Generated() bool {
+	return variable.Type != RegexType || variable.GenerationRegex != nil
+}
+
+func (variable *Variable) Names(name string) []string {
+	names := []string{name}
+	if variable.Type == FeederType {
+		names = append(names, variable.Feeder.Names(name)...)
+	}
+	return names
+}
+
+func (variable *Variable) Dependencies() []string {
+	if variable.expression == nil {
+		return nil
+	}
+	return variable.expression.Variables()
 }
 
-func (variable *Variable) Generate(userRand *rand.Rand) string {
-	return reggen.Generate(variable.GenerationRegex, regexManyCharactersLimit, userRand)
+func (variable *Variable) Generate(name string, userRand *rand.Rand, values map[string]string) error {
+	switch variable.Type {
+	case RegexType:
+		values[name] = reggen.Generate(variable.GenerationRegex, regexManyCharactersLimit, userRand)
+	case FeederType:
+		return variable.Feeder.Feed(name, userRand, values)
+	default:
+		values[name] = variable.generateValue(name, userRand, values)
+	}
+	return nil
 }
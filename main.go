@@ -9,12 +9,12 @@ import (
 	"reflect"
 	"regexp"
 	"regexp/syntax"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/mitchellh/mapstructure"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/viper"
@@ -22,16 +22,22 @@ import (
 
 	"github.com/ledokol-inc/ledokol/discovery"
 	"github.com/ledokol-inc/ledokol/load"
+	"github.com/ledokol-inc/ledokol/load/variables"
 	"github.com/ledokol-inc/ledokol/logger"
 )
 
 const portDefault = 1455
 const defaultLogLevel = "info"
+const defaultSinkInterval = "1s"
+const defaultRemoteWriteInterval = "5s"
+const defaultDataDirectory = "./data"
+const defaultReportsLimit = 100
+const defaultResultsDirectory = "./results"
 
 func main() {
 
 	rand.Seed(time.Now().UnixNano())
-	runningTests := make(map[string]*load.Test)
+	runningTests := newTests()
 
 	viper.SetConfigFile("config.yaml")
 	err := viper.ReadInConfig()
@@ -66,6 +72,15 @@ func main() {
 		log.Logger = log.Output(zerolog.MultiLevelWriter(fileLogger))
 	}
 
+	viper.SetDefault("data.directory", defaultDataDirectory)
+	variables.SetDataDirectory(viper.GetString("data.directory"))
+	viper.SetDefault("results.directory", defaultResultsDirectory)
+	load.SetResultsDirectory(viper.GetString("results.directory"))
+	viper.SetDefault("reports.limit", defaultReportsLimit)
+	testReports := newReports(viper.GetInt("reports.limit"))
+	load.SetSinks(configureSinks())
+	load.SetRemoteWrite(configureRemoteWrite())
+
 	router := gin.New()
 	router.Use(logger.Logger())
 
@@ -75,6 +90,7 @@ func main() {
 	port := viper.GetInt("server.http-port")
 
 	service := discovery.RegisterInConsul(port)
+	configureMetricLabels(service, port)
 
 	interruptChan := make(chan os.Signal, 1)
 	signal.Notify(interruptChan, os.Interrupt, syscall.SIGTERM)
@@ -83,10 +99,11 @@ func main() {
 		if service != nil {
 			service.DeregisterInConsul()
 		}
+		load.CloseSinks()
 		os.Exit(1)
 	}()
 
-	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	router.GET("/metrics", gin.WrapH(load.MetricsHandler()))
 	router.GET("/health", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"message": "Consul check"})
 	})
@@ -122,11 +139,10 @@ func main() {
 
 		test.SetOptions(&options)
 
-		if err := runTest(&test, runningTests, service); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		if err := runTest(&test, runningTests, testReports, service); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		} else {
 			c.JSON(http.StatusOK, gin.H{"message": "Тест запущен"})
-			runningTests[test.Id] = &test
 		}
 	})
 
@@ -139,17 +155,109 @@ func main() {
 		}
 	})
 
+	router.GET("/:id/report", func(c *gin.Context) {
+		id := c.Param("id")
+		report := testReports.get(id)
+		if report == nil {
+			c.String(http.StatusNotFound, "Тест с таким id не найден")
+			return
+		}
+
+		if c.Query("format") != "html" {
+			c.JSON(http.StatusOK, report)
+			return
+		}
+		c.Header("Content-Type", "text/html; charset=utf-8")
+		c.Status(http.StatusOK)
+		if err := report.WriteHtml(c.Writer); err != nil {
+			log.Error().Err(err).Str("testRunId", id).Msg("Не удалось сформировать HTML отчет")
+		}
+	})
+
 	err = router.Run(fmt.Sprintf(":%d", port))
 	log.Fatal().Err(err).Msg("ListenAndServe() error")
 }
 
-func runTest(test *load.Test, runningTests map[string]*load.Test, service *discovery.Service) error {
-	test.PrepareTest()
+// configureMetricLabels включает метки test_run_id и generator_id. По умолчанию generator_id - id сервиса в consul,
+// тот же, что передается главному сервису, а без регистрации в consul - имя хоста и порт.
+func configureMetricLabels(service *discovery.Service, port int) {
+	viper.SetDefault("metrics.labels.test-run-id", true)
+	viper.SetDefault("metrics.labels.generator-id", true)
+	_ = viper.BindEnv("metrics.generator-id", "generator_id")
+
+	generatorId := ""
+	if viper.GetBool("metrics.labels.generator-id") {
+		generatorId = viper.GetString("metrics.generator-id")
+		if generatorId == "" && service != nil {
+			generatorId = service.ServiceId()
+		}
+		if generatorId == "" {
+			hostname, _ := os.Hostname()
+			generatorId = fmt.Sprintf("%s:%d", hostname, port)
+		}
+	}
+	load.SetMetricLabels(viper.GetBool("metrics.labels.test-run-id"), generatorId)
+}
+
+// configureSinks создает приемники метрик, для которых в конфигурации задан адрес
+func configureSinks() []load.Sink {
+	viper.SetDefault("sinks.interval", defaultSinkInterval)
+	interval := viper.GetDuration("sinks.interval")
+	var sinks []load.Sink
 
-	go func(runningTests map[string]*load.Test, test *load.Test) {
+	if url := viper.GetString("sinks.influx.url"); url != "" {
+		sinks = append(sinks, load.NewInfluxHttpSink(url, viper.GetString("sinks.influx.token"), interval))
+	}
+	if address := viper.GetString("sinks.influx.udp-address"); address != "" {
+		sink, err := load.NewInfluxUdpSink(address, interval)
+		if err != nil {
+			log.Fatal().Err(err).Str("address", address).Msg("Не удалось создать приемник метрик InfluxDB")
+		}
+		sinks = append(sinks, sink)
+	}
+	if address := viper.GetString("sinks.graphite.address"); address != "" {
+		sinks = append(sinks, load.NewGraphiteSink(address, viper.GetString("sinks.graphite.prefix"), interval))
+	}
+	if address := viper.GetString("sinks.statsd.address"); address != "" {
+		sink, err := load.NewStatsdSink(address, viper.GetString("sinks.statsd.prefix"), viper.GetBool("sinks.statsd.tags"))
+		if err != nil {
+			log.Fatal().Err(err).Str("address", address).Msg("Не удалось создать приемник метрик StatsD")
+		}
+		sinks = append(sinks, sink)
+	}
+	return sinks
+}
+
+// configureRemoteWrite включает отправку метрик в Prometheus remote-write, если задан адрес
+func configureRemoteWrite() *load.RemoteWriteClient {
+	url := viper.GetString("remote-write.url")
+	if url == "" {
+		return nil
+	}
+	viper.SetDefault("remote-write.interval", defaultRemoteWriteInterval)
+	return load.NewRemoteWriteClient(&load.RemoteWriteOptions{
+		Url:         url,
+		Interval:    viper.GetDuration("remote-write.interval"),
+		BearerToken: viper.GetString("remote-write.bearer-token"),
+		Username:    viper.GetString("remote-write.username"),
+		Password:    viper.GetString("remote-write.password"),
+	})
+}
+
+func runTest(test *load.Test, runningTests *tests, testReports *reports, service *discovery.Service) error {
+	if err := test.PrepareTest(); err != nil {
+		return err
+	}
+	runningTests.add(test)
+	testReports.start(test)
+
+	go func(runningTests *tests, test *load.Test) {
 		test.Run()
-		if _, contains := runningTests[test.Id]; contains {
-			delete(runningTests, test.Id)
+		report := testReports.finish(test)
+		log.Info().Str("testRunId", test.Id).Uint64("transactions", report.Transactions.Count).
+			Uint64("failed", report.Transactions.Failed).Float64("p95", report.Transactions.P95).
+			Msg("Тест завершен")
+		if runningTests.remove(test) {
 			if service != nil {
 				service.SendEndTestRequestToMain(test.Id)
 			}
@@ -159,15 +267,105 @@ func runTest(test *load.Test, runningTests map[string]*load.Test, service *disco
 	return nil
 }
 
-func stopTest(testId string, runningTests map[string]*load.Test) bool {
-	test, exist := runningTests[testId]
-	if exist {
-		delete(runningTests, testId)
-		test.Stop()
-		return true
-	} else {
+// reports отдает отчеты тестов от запуска до завершения, в том числе во время остановки, и хранит итоговые
+// отчеты последних limit завершенных тестов
+type reports struct {
+	mutex    sync.Mutex
+	running  map[string]*load.Test
+	finished map[string]*load.Report
+	order    []string
+	limit    int
+}
+
+func newReports(limit int) *reports {
+	return &reports{running: make(map[string]*load.Test), finished: make(map[string]*load.Report), limit: limit}
+}
+
+func (reports *reports) start(test *load.Test) {
+	reports.mutex.Lock()
+	reports.running[test.Id] = test
+	reports.mutex.Unlock()
+}
+
+// finish сохраняет итоговый отчет теста, самый старый отчет удаляется при превышении limit
+func (reports *reports) finish(test *load.Test) *load.Report {
+	report := test.Report()
+	reports.mutex.Lock()
+	defer reports.mutex.Unlock()
+	if reports.running[test.Id] == test {
+		delete(reports.running, test.Id)
+	}
+	if _, exists := reports.finished[test.Id]; exists {
+		for i, id := range reports.order {
+			if id == test.Id {
+				reports.order = append(reports.order[:i], reports.order[i+1:]...)
+				break
+			}
+		}
+	}
+	reports.finished[test.Id] = report
+	reports.order = append(reports.order, test.Id)
+	for len(reports.order) > reports.limit {
+		delete(reports.finished, reports.order[0])
+		reports.order = reports.order[1:]
+	}
+	return report
+}
+
+func (reports *reports) get(id string) *load.Report {
+	reports.mutex.Lock()
+	test, running := reports.running[id]
+	report := reports.finished[id]
+	reports.mutex.Unlock()
+	if running {
+		return test.Report()
+	}
+	return report
+}
+
+// tests хранит выполняющиеся тесты, о завершении которых нужно сообщить главному сервису
+type tests struct {
+	mutex sync.Mutex
+	tests map[string]*load.Test
+}
+
+func newTests() *tests {
+	return &tests{tests: make(map[string]*load.Test)}
+}
+
+func (tests *tests) add(test *load.Test) {
+	tests.mutex.Lock()
+	tests.tests[test.Id] = test
+	tests.mutex.Unlock()
+}
+
+// remove удаляет тест и возвращает false, если он уже был удален при остановке
+func (tests *tests) remove(test *load.Test) bool {
+	tests.mutex.Lock()
+	defer tests.mutex.Unlock()
+	if tests.tests[test.Id] != test {
+		return false
+	}
+	delete(tests.tests, test.Id)
+	return true
+}
+
+// take удаляет и возвращает тест с указанным id
+func (tests *tests) take(id string) *load.Test {
+	tests.mutex.Lock()
+	defer tests.mutex.Unlock()
+	test := tests.tests[id]
+	delete(tests.tests, id)
+	return test
+}
+
+func stopTest(testId string, runningTests *tests) bool {
+	test := runningTests.take(testId)
+	if test == nil {
 		return false
 	}
+	test.Stop()
+	return true
 }
 
 func unmarshalSyntaxRegexp(_ reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {